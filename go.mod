@@ -4,6 +4,8 @@ go 1.22.3
 
 require github.com/hajimehoshi/ebiten/v2 v2.7.8
 
+require gopkg.in/yaml.v3 v3.0.1
+
 require (
 	github.com/aquilax/go-perlin v1.1.0
 	github.com/ebitengine/gomobile v0.0.0-20240518074828-e86332849895 // indirect
@@ -3,6 +3,7 @@ package main
 import (
 	"image/color"
 	"log"
+	"os"
 
 	"artificialLife/simulation"
 
@@ -19,9 +20,12 @@ func main() {
 	var sim simulation.Simulation
 
 	// Choose the simulation mode and type
-	simType := "random_walker" // "game_of_life", "schelling", "brians_brain", "terrain" or "random_walker"
+	simType := "random_walker" // "game_of_life", "schelling", "brians_brain", "terrain", "terrain_world", "lenia", "smooth_life", "gray_scott", "forest_fire", "sandpile", "schelling_sweep", "sugarscape", "boids" or "random_walker"
 	threshold := 0.1           // Satisfaction threshold for Schelling model
 	frameRate := 10            // Frame rate for the simulation
+	biomeFile := ""            // Optional JSON or YAML biome definitions for the terrain
+	onTerrain := false         // Run the schelling and random_walker agents on a generated terrain
+	maskFile := ""             // Optional grayscale PNG shaping the terrain's land, white for land
 
 	switch simType {
 	case "game_of_life":
@@ -36,13 +40,62 @@ func main() {
 			color.RGBA{255, 0, 0, 255}, // Red
 			color.RGBA{0, 0, 255, 255}, // Blue
 		}
-		sim = simulation.NewSchelling(screenWidth/cellSize, screenHeight/cellSize, threshold, groupColors)
+		var opts []simulation.SchellingOption
+		if onTerrain {
+			terrain := simulation.NewTerrain(screenWidth/cellSize, screenHeight/cellSize, 0, simulation.GetBiomes())
+			opts = append(opts, simulation.WithTerrain(simulation.NewTerrainSubstrate(terrain, simulation.DefaultTerrainTraits)))
+		}
+		sim = simulation.NewSchelling(screenWidth/cellSize, screenHeight/cellSize, threshold, groupColors, opts...)
+	case "schelling_sweep":
+		runSchellingSweep()
+		return
 	case "brians_brain":
 		sim = simulation.NewBriansBrain(screenWidth/cellSize, screenHeight/cellSize)
 	case "terrain":
-		sim = simulation.NewTerrain(screenWidth/cellSize, screenHeight/cellSize, 0, simulation.GetBiomes())
+		biomes := simulation.GetBiomes()
+		if biomeFile != "" {
+			loaded, err := simulation.LoadBiomes(biomeFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			biomes = loaded
+		}
+		terrain := simulation.NewTerrain(screenWidth/cellSize, screenHeight/cellSize, 0, biomes)
+		if maskFile != "" {
+			img, err := simulation.LoadMaskPNG(maskFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			mask := simulation.DefaultMaskParams
+			mask.Shape, mask.Image = simulation.ImageMask, img
+			terrain.SetMask(mask)
+		}
+		sim = terrain
+	case "terrain_world":
+		sim = simulation.NewTerrainWorld(screenWidth/cellSize, screenHeight/cellSize, 0, simulation.GetBiomes())
+		frameRate = 30 // Keep panning smooth
+	case "lenia":
+		sim = simulation.NewLenia(screenWidth/cellSize, screenHeight/cellSize, simulation.OrbiumParams, simulation.ViridisColormap)
+	case "smooth_life":
+		sim = simulation.NewSmoothLife(screenWidth/cellSize, screenHeight/cellSize, simulation.DefaultSmoothLifeParams, simulation.GrayColormap)
+	case "gray_scott":
+		sim = simulation.NewGrayScott(screenWidth/cellSize, screenHeight/cellSize, simulation.MitosisPreset, simulation.FieldV, simulation.InfernoColormap)
+	case "forest_fire":
+		sim = simulation.NewForestFire(screenWidth/cellSize, screenHeight/cellSize, 0, simulation.PlainsBiome, simulation.DefaultFireParams)
+	case "sandpile":
+		sim = simulation.NewSandpile(screenWidth/cellSize, screenHeight/cellSize, simulation.DropCenter)
+	case "sugarscape":
+		sim = simulation.NewSugarscape(screenWidth/cellSize, screenHeight/cellSize, simulation.DefaultSugarscapeParams)
+	case "boids":
+		sim = simulation.NewBoids(screenWidth/cellSize, screenHeight/cellSize, simulation.DefaultBoidsParams)
+		frameRate = 60 // Continuous motion needs a smooth frame rate
 	case "random_walker":
-		sim = simulation.NewrandomWalker(screenWidth/cellSize, screenHeight/cellSize)
+		if onTerrain {
+			terrain := simulation.NewTerrain(screenWidth/cellSize, screenHeight/cellSize, 0, simulation.GetBiomes())
+			sim = simulation.NewTerrainWalker(simulation.NewTerrainSubstrate(terrain, simulation.DefaultTerrainTraits), 300)
+		} else {
+			sim = simulation.NewrandomWalker(screenWidth/cellSize, screenHeight/cellSize)
+		}
 	default:
 		log.Fatal("Invalid simulation type")
 
@@ -55,3 +108,29 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// runSchellingSweep runs Schelling headless over a parameter grid and writes
+// the results to schelling_sweep.csv instead of opening a window.
+func runSchellingSweep() {
+	sweep := simulation.SchellingSweep{
+		Width:       50,
+		Height:      50,
+		Thresholds:  []float64{0.3, 0.4, 0.5, 0.6, 0.7},
+		EmptyRatios: []float64{0.05, 0.1, 0.2},
+		Proportions: [][]float64{{0.5, 0.5}, {0.7, 0.3}, {1, 1, 1}},
+		Radii:       []int{1, 2},
+		Replicates:  5,
+		MaxSteps:    500,
+	}
+
+	f, err := os.Create("schelling_sweep.csv")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := sweep.Run(f); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,265 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var terrainClassNames = map[TerrainClass]string{
+	TerrainWater:  "water",
+	TerrainSand:   "sand",
+	TerrainGrass:  "grass",
+	TerrainForest: "forest",
+	TerrainRock:   "rock",
+	TerrainSnow:   "snow",
+}
+
+func (c TerrainClass) String() string {
+	if name, ok := terrainClassNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+func parseTerrainClass(name string) (TerrainClass, error) {
+	for class, n := range terrainClassNames {
+		if strings.EqualFold(n, name) {
+			return class, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown terrain class %q", name)
+}
+
+// BiomeBand is one height band of a biome as stored in a biome file. Colors
+// are written as "#rrggbb" or "#rrggbbaa".
+type BiomeBand struct {
+	Label     string  `json:"label" yaml:"label"`
+	Threshold float64 `json:"threshold" yaml:"threshold"`
+	Color     string  `json:"color" yaml:"color"`
+	Class     string  `json:"class" yaml:"class"`
+}
+
+type BiomeDefinition struct {
+	Name  string      `json:"name" yaml:"name"`
+	Bands []BiomeBand `json:"bands" yaml:"bands"`
+}
+
+type biomeFile struct {
+	Biomes []BiomeDefinition `json:"biomes" yaml:"biomes"`
+}
+
+func (b Biome) Name() string {
+	return b.name
+}
+
+// Validate checks that the biome has a name and bands whose thresholds rise
+// strictly and end at exactly 1.0, so every height from 0 to 1 falls in
+// exactly one band.
+func (b Biome) Validate() error {
+	if b.name == "" {
+		return errors.New("biome has no name")
+	}
+	if len(b.terrainColors) == 0 {
+		return fmt.Errorf("biome %q has no bands", b.name)
+	}
+	previous := 0.0
+	for i, band := range b.terrainColors {
+		if band.threshold < 0 || band.threshold > 1 {
+			return fmt.Errorf("biome %q band %d (%s): threshold %g is outside 0-1", b.name, i, band.label, band.threshold)
+		}
+		if i > 0 && band.threshold <= previous {
+			return fmt.Errorf("biome %q band %d (%s): threshold %g is not above the previous %g", b.name, i, band.label, band.threshold, previous)
+		}
+		previous = band.threshold
+	}
+	if previous != 1.0 {
+		return fmt.Errorf("biome %q: last threshold is %g, must be 1.0", b.name, previous)
+	}
+	return nil
+}
+
+// Definition converts the biome to its file form.
+func (b Biome) Definition() BiomeDefinition {
+	def := BiomeDefinition{Name: b.name}
+	for _, band := range b.terrainColors {
+		c := color.RGBAModel.Convert(band.color).(color.RGBA)
+		hex := fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+		if c.A != 255 {
+			hex += fmt.Sprintf("%02x", c.A)
+		}
+		def.Bands = append(def.Bands, BiomeBand{
+			Label:     band.label,
+			Threshold: band.threshold,
+			Color:     hex,
+			Class:     band.class.String(),
+		})
+	}
+	return def
+}
+
+// NewBiome builds and validates a biome from its file form.
+func NewBiome(def BiomeDefinition) (Biome, error) {
+	b := Biome{name: def.Name}
+	for i, band := range def.Bands {
+		c, err := parseHexColor(band.Color)
+		if err != nil {
+			return Biome{}, fmt.Errorf("biome %q band %d (%s): %v", def.Name, i, band.Label, err)
+		}
+		class, err := parseTerrainClass(band.Class)
+		if err != nil {
+			return Biome{}, fmt.Errorf("biome %q band %d (%s): %v", def.Name, i, band.Label, err)
+		}
+		b.terrainColors = append(b.terrainColors, terrainColor{
+			label:     band.Label,
+			color:     c,
+			threshold: band.Threshold,
+			class:     class,
+		})
+	}
+	if err := b.Validate(); err != nil {
+		return Biome{}, err
+	}
+	return b, nil
+}
+
+func parseHexColor(s string) (color.RGBA, error) {
+	if s == "" {
+		// An unquoted #rrggbb in YAML is read as a comment
+		return color.RGBA{}, errors.New(`missing color; in YAML, quote colors as "#rrggbb"`)
+	}
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("color %q is not #rrggbb or #rrggbbaa", s)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("color %q is not #rrggbb or #rrggbbaa", s)
+	}
+	return color.RGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
+func decodeBiomes(file biomeFile) ([]Biome, error) {
+	if len(file.Biomes) == 0 {
+		return nil, errors.New("no biomes defined")
+	}
+	biomes := make([]Biome, 0, len(file.Biomes))
+	for _, def := range file.Biomes {
+		b, err := NewBiome(def)
+		if err != nil {
+			return nil, err
+		}
+		biomes = append(biomes, b)
+	}
+	return biomes, nil
+}
+
+func encodeBiomes(biomes []Biome) (biomeFile, error) {
+	var file biomeFile
+	for _, b := range biomes {
+		if err := b.Validate(); err != nil {
+			return biomeFile{}, err
+		}
+		file.Biomes = append(file.Biomes, b.Definition())
+	}
+	return file, nil
+}
+
+// ReadBiomesJSON reads biomes from a JSON document of the form
+// {"biomes": [{"name": ..., "bands": [{"label", "threshold", "color", "class"}]}]}.
+func ReadBiomesJSON(r io.Reader) ([]Biome, error) {
+	var file biomeFile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	return decodeBiomes(file)
+}
+
+func WriteBiomesJSON(w io.Writer, biomes []Biome) error {
+	file, err := encodeBiomes(biomes)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(file)
+}
+
+// ReadBiomesYAML reads the same structure as ReadBiomesJSON written as YAML.
+func ReadBiomesYAML(r io.Reader) ([]Biome, error) {
+	var file biomeFile
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	return decodeBiomes(file)
+}
+
+func WriteBiomesYAML(w io.Writer, biomes []Biome) error {
+	file, err := encodeBiomes(biomes)
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(file); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func isYAMLPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// LoadBiomes reads biome definitions from a .json, .yaml or .yml file.
+func LoadBiomes(path string) ([]Biome, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var biomes []Biome
+	if isYAMLPath(path) {
+		biomes, err = ReadBiomesYAML(f)
+	} else {
+		biomes, err = ReadBiomesJSON(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return biomes, nil
+}
+
+// SaveBiomes writes biomes to path as YAML for .yaml and .yml files and JSON
+// otherwise. Biomes that fail validation are not written.
+func SaveBiomes(path string, biomes []Biome) error {
+	var buf bytes.Buffer
+	var err error
+	if isYAMLPath(path) {
+		err = WriteBiomesYAML(&buf, biomes)
+	} else {
+		err = WriteBiomesJSON(&buf, biomes)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
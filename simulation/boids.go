@@ -0,0 +1,377 @@
+package simulation
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+type BoidsParams struct {
+	Count              int
+	Predators          int
+	Obstacles          int
+	MaxSpeed           float64 // Pixels per update
+	PredatorSpeed      float64
+	MaxForce           float64 // Largest change in velocity per update
+	Perception         float64 // Radius within which boids see each other
+	SeparationDistance float64 // Boids closer than this push apart
+	SeparationWeight   float64
+	AlignmentWeight    float64
+	CohesionWeight     float64
+	AvoidanceWeight    float64 // Steering away from obstacles
+	FleeWeight         float64 // Steering away from predators
+}
+
+var DefaultBoidsParams = BoidsParams{
+	Count:              2000,
+	Predators:          3,
+	Obstacles:          5,
+	MaxSpeed:           4,
+	PredatorSpeed:      4.5,
+	MaxForce:           0.2,
+	Perception:         25,
+	SeparationDistance: 10,
+	SeparationWeight:   1.5,
+	AlignmentWeight:    1,
+	CohesionWeight:     1,
+	AvoidanceWeight:    3,
+	FleeWeight:         3,
+}
+
+type boid struct {
+	x, y     float64
+	vx, vy   float64
+	predator bool
+}
+
+type obstacle struct {
+	x, y, radius float64
+}
+
+// spatialHash buckets boids into square cells as wide as the perception
+// radius, so a neighbor query only has to look at the 3x3 cells around a
+// boid instead of the whole flock.
+type spatialHash struct {
+	cellSize float64
+	cols     int
+	rows     int
+	cells    [][]int
+}
+
+func newSpatialHash(width, height, cellSize float64) *spatialHash {
+	cols := max(1, int(width/cellSize))
+	rows := max(1, int(height/cellSize))
+	return &spatialHash{
+		cellSize: cellSize,
+		cols:     cols,
+		rows:     rows,
+		cells:    make([][]int, cols*rows),
+	}
+}
+
+func (h *spatialHash) cell(x, y float64) (int, int) {
+	cx := int(x/h.cellSize) % h.cols
+	cy := int(y/h.cellSize) % h.rows
+	return cx, cy
+}
+
+func (h *spatialHash) rebuild(boids []boid) {
+	for i := range h.cells {
+		h.cells[i] = h.cells[i][:0]
+	}
+	for i, b := range boids {
+		cx, cy := h.cell(b.x, b.y)
+		h.cells[cy*h.cols+cx] = append(h.cells[cy*h.cols+cx], i)
+	}
+}
+
+// near calls fn with the index of every boid in the cells around (x, y).
+func (h *spatialHash) near(x, y float64, fn func(i int)) {
+	cx, cy := h.cell(x, y)
+	var visited [9]int
+	count := 0
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			c := ((cy+dy+h.rows)%h.rows)*h.cols + (cx+dx+h.cols)%h.cols
+
+			// Small worlds wrap onto the same cell more than once
+			seen := false
+			for _, v := range visited[:count] {
+				seen = seen || v == c
+			}
+			if seen {
+				continue
+			}
+			visited[count] = c
+			count++
+
+			for _, i := range h.cells[c] {
+				fn(i)
+			}
+		}
+	}
+}
+
+type Boids struct {
+	BaseSimulation
+	width      float64
+	height     float64
+	gridWidth  int
+	gridHeight int
+	params     BoidsParams
+	boids      []boid
+	next       []boid
+	obstacles  []obstacle
+	hash       *spatialHash
+	vertices   []ebiten.Vertex
+	indices    []uint16
+	whiteImage *ebiten.Image
+}
+
+func NewBoids(width, height int, params BoidsParams) *Boids {
+	b := &Boids{
+		width:      float64(width * cellSize),
+		height:     float64(height * cellSize),
+		gridWidth:  width,
+		gridHeight: height,
+		params:     params,
+		whiteImage: ebiten.NewImage(3, 3),
+	}
+	b.whiteImage.Fill(color.White)
+	b.hash = newSpatialHash(b.width, b.height, params.Perception)
+
+	for i := 0; i < params.Count+params.Predators; i++ {
+		angle := rand.Float64() * 2 * math.Pi
+		b.boids = append(b.boids, boid{
+			x:        rand.Float64() * b.width,
+			y:        rand.Float64() * b.height,
+			vx:       math.Cos(angle) * params.MaxSpeed,
+			vy:       math.Sin(angle) * params.MaxSpeed,
+			predator: i >= params.Count,
+		})
+	}
+	b.next = make([]boid, len(b.boids))
+
+	for i := 0; i < params.Obstacles; i++ {
+		b.obstacles = append(b.obstacles, obstacle{
+			x:      rand.Float64() * b.width,
+			y:      rand.Float64() * b.height,
+			radius: 15 + rand.Float64()*25,
+		})
+	}
+	return b
+}
+
+// offset returns the shortest vector from a to b on the wrapping world.
+func (b *Boids) offset(ax, ay, bx, by float64) (float64, float64) {
+	dx, dy := bx-ax, by-ay
+	if dx > b.width/2 {
+		dx -= b.width
+	} else if dx < -b.width/2 {
+		dx += b.width
+	}
+	if dy > b.height/2 {
+		dy -= b.height
+	} else if dy < -b.height/2 {
+		dy += b.height
+	}
+	return dx, dy
+}
+
+// steer returns the force turning velocity (vx, vy) towards the direction
+// (dx, dy) at full speed, limited to MaxForce.
+func (b *Boids) steer(dx, dy, vx, vy, speed float64) (float64, float64) {
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return 0, 0
+	}
+	fx := dx/length*speed - vx
+	fy := dy/length*speed - vy
+	return limit(fx, fy, b.params.MaxForce)
+}
+
+// limit scales (x, y) down to at most maxLength long.
+func limit(x, y, maxLength float64) (float64, float64) {
+	length := math.Hypot(x, y)
+	if length > maxLength {
+		return x / length * maxLength, y / length * maxLength
+	}
+	return x, y
+}
+
+func (b *Boids) flock(i int) boid {
+	p := b.params
+	self := b.boids[i]
+	speed := p.MaxSpeed
+	if self.predator {
+		speed = p.PredatorSpeed
+	}
+
+	var sepX, sepY, alignX, alignY, cohX, cohY, fleeX, fleeY float64
+	flockmates, threats := 0, 0
+	preyDist, preyX, preyY := math.Inf(1), 0.0, 0.0
+
+	b.hash.near(self.x, self.y, func(j int) {
+		if j == i {
+			return
+		}
+		other := b.boids[j]
+		dx, dy := b.offset(self.x, self.y, other.x, other.y)
+		dist := math.Hypot(dx, dy)
+		if dist > p.Perception || dist == 0 {
+			return
+		}
+
+		switch {
+		case self.predator && !other.predator:
+			if dist < preyDist {
+				preyDist, preyX, preyY = dist, dx, dy
+			}
+		case !self.predator && other.predator:
+			fleeX -= dx / dist
+			fleeY -= dy / dist
+			threats++
+		case !self.predator:
+			flockmates++
+			alignX += other.vx
+			alignY += other.vy
+			cohX += dx
+			cohY += dy
+			if dist < p.SeparationDistance {
+				sepX -= dx / (dist * dist)
+				sepY -= dy / (dist * dist)
+			}
+		}
+	})
+
+	var ax, ay float64
+	// add steers towards (dx, dy) with the given weight
+	add := func(dx, dy, weight float64) {
+		fx, fy := b.steer(dx, dy, self.vx, self.vy, speed)
+		ax += fx * weight
+		ay += fy * weight
+	}
+
+	if self.predator {
+		if !math.IsInf(preyDist, 1) {
+			add(preyX, preyY, 1)
+		}
+	} else {
+		if flockmates > 0 {
+			add(sepX, sepY, p.SeparationWeight)
+			add(alignX, alignY, p.AlignmentWeight)
+			add(cohX, cohY, p.CohesionWeight)
+		}
+		if threats > 0 {
+			add(fleeX, fleeY, p.FleeWeight)
+		}
+	}
+
+	for _, o := range b.obstacles {
+		dx, dy := b.offset(o.x, o.y, self.x, self.y)
+		dist := math.Hypot(dx, dy)
+		if dist < o.radius+p.Perception && dist > 0 {
+			// Push harder the closer the boid gets to the edge
+			strength := (o.radius + p.Perception - dist) / p.Perception
+			add(dx, dy, p.AvoidanceWeight*strength)
+		}
+	}
+
+	self.vx, self.vy = limit(self.vx+ax, self.vy+ay, speed)
+	self.x = math.Mod(self.x+self.vx+b.width, b.width)
+	self.y = math.Mod(self.y+self.vy+b.height, b.height)
+	return self
+}
+
+func (b *Boids) Update() error {
+	b.UpdatePauseState()
+
+	// Right-click to drop an obstacle under the cursor
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) {
+		x, y := ebiten.CursorPosition()
+		b.obstacles = append(b.obstacles, obstacle{x: float64(x), y: float64(y), radius: 20})
+	}
+
+	if b.IsPaused() {
+		return nil
+	}
+
+	b.hash.rebuild(b.boids)
+	for i := range b.boids {
+		b.next[i] = b.flock(i)
+	}
+	b.boids, b.next = b.next, b.boids
+	return nil
+}
+
+// appendTriangle adds a triangle pointing along the boid's velocity.
+func (b *Boids) appendTriangle(bd boid, size float32, c color.RGBA) {
+	speed := math.Hypot(bd.vx, bd.vy)
+	dirX, dirY := float32(1), float32(0)
+	if speed > 0 {
+		dirX, dirY = float32(bd.vx/speed), float32(bd.vy/speed)
+	}
+	x, y := float32(bd.x), float32(bd.y)
+
+	r, g, bl, a := float32(c.R)/255, float32(c.G)/255, float32(c.B)/255, float32(c.A)/255
+	base := uint16(len(b.vertices))
+	for _, p := range [][2]float32{
+		{x + dirX*size, y + dirY*size},
+		{x - dirX*size*0.6 - dirY*size*0.5, y - dirY*size*0.6 + dirX*size*0.5},
+		{x - dirX*size*0.6 + dirY*size*0.5, y - dirY*size*0.6 - dirX*size*0.5},
+	} {
+		b.vertices = append(b.vertices, ebiten.Vertex{
+			DstX: p[0], DstY: p[1],
+			SrcX: 1, SrcY: 1,
+			ColorR: r, ColorG: g, ColorB: bl, ColorA: a,
+		})
+	}
+	b.indices = append(b.indices, base, base+1, base+2)
+}
+
+// maxBatchVertices keeps each DrawTriangles call within uint16 indices.
+const maxBatchVertices = 65535 - 65535%3
+
+func (b *Boids) Draw(screen *ebiten.Image) {
+	screen.Fill(color.RGBA{10, 10, 30, 255})
+
+	for _, o := range b.obstacles {
+		vector.DrawFilledCircle(screen, float32(o.x), float32(o.y), float32(o.radius), color.RGBA{90, 90, 90, 255}, true)
+	}
+
+	src := b.whiteImage.SubImage(image.Rect(1, 1, 2, 2)).(*ebiten.Image)
+	flush := func() {
+		if len(b.vertices) > 0 {
+			screen.DrawTriangles(b.vertices, b.indices, src, &ebiten.DrawTrianglesOptions{})
+		}
+		b.vertices = b.vertices[:0]
+		b.indices = b.indices[:0]
+	}
+	for _, bd := range b.boids {
+		if len(b.vertices)+3 > maxBatchVertices {
+			flush()
+		}
+		if bd.predator {
+			b.appendTriangle(bd, 9, color.RGBA{255, 60, 60, 255})
+		} else {
+			b.appendTriangle(bd, 5, color.RGBA{180, 230, 255, 255})
+		}
+	}
+	flush()
+
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("Boids: %d  Predators: %d  TPS: %.0f", b.params.Count, b.params.Predators, ebiten.ActualTPS()))
+	if b.IsPaused() {
+		ebitenutil.DebugPrintAt(screen, "Paused", screen.Bounds().Dx()/2-30, screen.Bounds().Dy()/2)
+	}
+}
+
+func (b *Boids) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return b.gridWidth * cellSize, b.gridHeight * cellSize
+}
@@ -0,0 +1,90 @@
+package simulation
+
+import (
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Colormap maps a value in [0, 1] to a color by interpolating linearly
+// between evenly spaced stops.
+type Colormap []color.RGBA
+
+var (
+	GrayColormap = Colormap{
+		{0, 0, 0, 255},
+		{255, 255, 255, 255},
+	}
+
+	ViridisColormap = Colormap{
+		{68, 1, 84, 255},
+		{59, 82, 139, 255},
+		{33, 145, 140, 255},
+		{94, 201, 98, 255},
+		{253, 231, 37, 255},
+	}
+
+	InfernoColormap = Colormap{
+		{0, 0, 4, 255},
+		{87, 16, 110, 255},
+		{188, 55, 84, 255},
+		{249, 142, 9, 255},
+		{252, 255, 164, 255},
+	}
+)
+
+func (c Colormap) At(v float64) color.RGBA {
+	if len(c) == 1 {
+		return c[0]
+	}
+	if v <= 0 {
+		return c[0]
+	}
+	if v >= 1 {
+		return c[len(c)-1]
+	}
+
+	pos := v * float64(len(c)-1)
+	i := int(pos)
+	frac := pos - float64(i)
+	a, b := c[i], c[i+1]
+	return color.RGBA{
+		R: uint8(float64(a.R) + (float64(b.R)-float64(a.R))*frac),
+		G: uint8(float64(a.G) + (float64(b.G)-float64(a.G))*frac),
+		B: uint8(float64(a.B) + (float64(b.B)-float64(a.B))*frac),
+		A: 255,
+	}
+}
+
+// fieldRenderer draws a scalar field through a colormap with a single pixel
+// upload, since one DrawRect per cell is too slow for the continuous models.
+type fieldRenderer struct {
+	width  int
+	height int
+	image  *ebiten.Image
+	pixels []byte
+}
+
+func newFieldRenderer(width, height int) *fieldRenderer {
+	return &fieldRenderer{
+		width:  width,
+		height: height,
+		image:  ebiten.NewImage(width, height),
+		pixels: make([]byte, 4*width*height),
+	}
+}
+
+func (r *fieldRenderer) draw(screen *ebiten.Image, field []float64, cmap Colormap) {
+	for i, v := range field {
+		c := cmap.At(v)
+		r.pixels[4*i] = c.R
+		r.pixels[4*i+1] = c.G
+		r.pixels[4*i+2] = c.B
+		r.pixels[4*i+3] = c.A
+	}
+	r.image.WritePixels(r.pixels)
+
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(cellSize, cellSize)
+	screen.DrawImage(r.image, op)
+}
@@ -0,0 +1,237 @@
+package simulation
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fftPlan holds the precomputed tables for a 1D FFT of a fixed length.
+// Power-of-two lengths use an iterative radix-2 transform; any other length
+// goes through Bluestein's algorithm on top of a padded radix-2 plan, so the
+// grid sizes derived from the screen size don't need to be powers of two.
+type fftPlan struct {
+	n        int
+	twiddles []complex128
+	bitrev   []int
+
+	// Bluestein state, only set when n is not a power of two
+	inner   *fftPlan
+	chirp   []complex128
+	filter  []complex128
+	scratch []complex128
+}
+
+func newFFTPlan(n int) *fftPlan {
+	p := &fftPlan{n: n}
+	if n&(n-1) == 0 {
+		bits := 0
+		for 1<<bits < n {
+			bits++
+		}
+		p.twiddles = make([]complex128, n/2)
+		for i := range p.twiddles {
+			p.twiddles[i] = cmplx.Rect(1, -2*math.Pi*float64(i)/float64(n))
+		}
+		p.bitrev = make([]int, n)
+		for i := range p.bitrev {
+			r := 0
+			for b := 0; b < bits; b++ {
+				if i&(1<<b) != 0 {
+					r |= 1 << (bits - 1 - b)
+				}
+			}
+			p.bitrev[i] = r
+		}
+		return p
+	}
+
+	m := 1
+	for m < 2*n-1 {
+		m <<= 1
+	}
+	p.inner = newFFTPlan(m)
+	p.chirp = make([]complex128, n)
+	for k := range p.chirp {
+		kk := (k * k) % (2 * n) // Keep the angle small to avoid losing precision
+		p.chirp[k] = cmplx.Rect(1, -math.Pi*float64(kk)/float64(n))
+	}
+	p.filter = make([]complex128, m)
+	p.filter[0] = cmplx.Conj(p.chirp[0])
+	for k := 1; k < n; k++ {
+		p.filter[k] = cmplx.Conj(p.chirp[k])
+		p.filter[m-k] = cmplx.Conj(p.chirp[k])
+	}
+	p.inner.forward(p.filter)
+	p.scratch = make([]complex128, m)
+	return p
+}
+
+// transform runs the FFT in place. The inverse transform is scaled by 1/n so
+// that a forward and inverse pass round-trip the input.
+func (p *fftPlan) transform(data []complex128, inverse bool) {
+	if !inverse {
+		p.forward(data)
+		return
+	}
+
+	for i := range data {
+		data[i] = cmplx.Conj(data[i])
+	}
+	p.forward(data)
+	scale := complex(1/float64(p.n), 0)
+	for i := range data {
+		data[i] = cmplx.Conj(data[i]) * scale
+	}
+}
+
+func (p *fftPlan) forward(data []complex128) {
+	if p.inner != nil {
+		p.bluestein(data)
+		return
+	}
+
+	for i, j := range p.bitrev {
+		if i < j {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+	for size := 2; size <= p.n; size <<= 1 {
+		half := size / 2
+		step := p.n / size
+		for start := 0; start < p.n; start += size {
+			for k := 0; k < half; k++ {
+				w := p.twiddles[k*step]
+				a := data[start+k]
+				b := data[start+k+half] * w
+				data[start+k] = a + b
+				data[start+k+half] = a - b
+			}
+		}
+	}
+}
+
+func (p *fftPlan) bluestein(data []complex128) {
+	s := p.scratch
+	for i := range s {
+		s[i] = 0
+	}
+	for k := 0; k < p.n; k++ {
+		s[k] = data[k] * p.chirp[k]
+	}
+	p.inner.forward(s)
+	for i := range s {
+		s[i] *= p.filter[i]
+	}
+	p.inner.transform(s, true)
+	for k := 0; k < p.n; k++ {
+		data[k] = s[k] * p.chirp[k]
+	}
+}
+
+// fft2D transforms a row-major width*height field by running the 1D plan over
+// every row and then every column.
+type fft2D struct {
+	width  int
+	height int
+	rows   *fftPlan
+	cols   *fftPlan
+	column []complex128
+}
+
+func newFFT2D(width, height int) *fft2D {
+	return &fft2D{
+		width:  width,
+		height: height,
+		rows:   newFFTPlan(width),
+		cols:   newFFTPlan(height),
+		column: make([]complex128, height),
+	}
+}
+
+func (f *fft2D) transform(data []complex128, inverse bool) {
+	for y := 0; y < f.height; y++ {
+		f.rows.transform(data[y*f.width:(y+1)*f.width], inverse)
+	}
+	for x := 0; x < f.width; x++ {
+		for y := 0; y < f.height; y++ {
+			f.column[y] = data[y*f.width+x]
+		}
+		f.cols.transform(f.column, inverse)
+		for y := 0; y < f.height; y++ {
+			data[y*f.width+x] = f.column[y]
+		}
+	}
+}
+
+// fftConvolver convolves a scalar field on a torus. The field is transformed
+// once by load and can then be convolved with several kernels whose spectra
+// were computed up front with kernelSpectrum.
+type fftConvolver struct {
+	fft      *fft2D
+	spectrum []complex128
+	buffer   []complex128
+}
+
+func newFFTConvolver(width, height int) *fftConvolver {
+	return &fftConvolver{
+		fft:      newFFT2D(width, height),
+		spectrum: make([]complex128, width*height),
+		buffer:   make([]complex128, width*height),
+	}
+}
+
+// kernelSpectrum transforms a kernel laid out with its centre at cell (0, 0),
+// negative offsets wrapping around to the far edges.
+func (c *fftConvolver) kernelSpectrum(kernel []float64) []complex128 {
+	spectrum := make([]complex128, len(kernel))
+	for i, v := range kernel {
+		spectrum[i] = complex(v, 0)
+	}
+	c.fft.transform(spectrum, false)
+	return spectrum
+}
+
+func (c *fftConvolver) load(field []float64) {
+	for i, v := range field {
+		c.spectrum[i] = complex(v, 0)
+	}
+	c.fft.transform(c.spectrum, false)
+}
+
+// apply writes the convolution of the loaded field with a kernel into dst.
+func (c *fftConvolver) apply(kernel []complex128, dst []float64) {
+	for i := range c.buffer {
+		c.buffer[i] = c.spectrum[i] * kernel[i]
+	}
+	c.fft.transform(c.buffer, true)
+	for i := range dst {
+		dst[i] = real(c.buffer[i])
+	}
+}
+
+// radialKernel builds a kernel centred at (0, 0) by sampling fn at the distance
+// in cells of every offset within extent, wrapping around the torus, and
+// normalises it so its weights sum to 1.
+func radialKernel(width, height int, extent float64, fn func(d float64) float64) []float64 {
+	kernel := make([]float64, width*height)
+	r := int(math.Ceil(extent))
+	sum := 0.0
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			v := fn(math.Hypot(float64(dx), float64(dy)))
+			if v == 0 {
+				continue
+			}
+			x := ((dx % width) + width) % width
+			y := ((dy % height) + height) % height
+			kernel[y*width+x] += v
+			sum += v
+		}
+	}
+	if sum > 0 {
+		for i := range kernel {
+			kernel[i] /= sum
+		}
+	}
+	return kernel
+}
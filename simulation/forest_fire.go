@@ -0,0 +1,208 @@
+package simulation
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"math/rand"
+	"os"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+type FireParams struct {
+	Lightning float64 // Probability per update that lightning strikes a given tree
+	Regrowth  float64 // Probability per update that an empty cell grows a tree, scaled by its fuel load
+}
+
+var DefaultFireParams = FireParams{
+	Lightning: 0.00002,
+	Regrowth:  0.01,
+}
+
+// fireFuel describes how a terrain class burns. The load scales regrowth and
+// how long a tree keeps burning; spread is the chance that a burning
+// neighbor ignites it.
+type fireFuel struct {
+	load   float64
+	spread float64
+}
+
+var fireFuels = map[TerrainClass]fireFuel{
+	TerrainWater:  {load: 0, spread: 0},
+	TerrainSand:   {load: 0, spread: 0},
+	TerrainGrass:  {load: 0.4, spread: 0.6},
+	TerrainForest: {load: 1, spread: 0.95},
+	TerrainRock:   {load: 0.1, spread: 0.2},
+	TerrainSnow:   {load: 0, spread: 0},
+}
+
+const maxBurnSteps = 3
+
+type fireCell struct {
+	tree    bool
+	burning int // Updates left until the tree burns out, 0 if not burning
+	fire    int // Id of the fire the cell belongs to while burning
+}
+
+type ForestFire struct {
+	BaseSimulation
+	grid      *Grid
+	terrain   *Terrain
+	params    FireParams
+	fuel      [][]fireFuel
+	cells     [][]fireCell
+	next      [][]fireCell
+	nextFire  int
+	fireSize  map[int]int // Cells ignited so far by each active fire
+	fireLeft  map[int]int // Cells still burning for each active fire
+	fireSizes LogHistogram
+}
+
+func NewForestFire(width, height int, seed int64, biome Biome, params FireParams) *ForestFire {
+	terrain := NewTerrain(width, height, seed, []Biome{biome})
+	ff := &ForestFire{
+		grid:     terrain.grid,
+		terrain:  terrain,
+		params:   params,
+		fuel:     make([][]fireFuel, height),
+		cells:    make([][]fireCell, height),
+		next:     make([][]fireCell, height),
+		fireSize: make(map[int]int),
+		fireLeft: make(map[int]int),
+	}
+
+	for y := 0; y < height; y++ {
+		ff.fuel[y] = make([]fireFuel, width)
+		ff.cells[y] = make([]fireCell, width)
+		ff.next[y] = make([]fireCell, width)
+		for x := 0; x < width; x++ {
+			ff.fuel[y][x] = fireFuels[terrain.classAt(x, y)]
+			ff.cells[y][x].tree = rand.Float64() < ff.fuel[y][x].load/2
+		}
+	}
+	return ff
+}
+
+func (ff *ForestFire) Update() error {
+	ff.UpdatePauseState()
+
+	// Press E to export the fire size histogram to the working directory
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		if err := ff.ExportFireSizes("fire_sizes.csv"); err != nil {
+			log.Printf("exporting fire sizes: %v", err)
+		}
+	}
+	if ff.IsPaused() {
+		return nil
+	}
+
+	for y := range ff.cells {
+		for x := range ff.cells[y] {
+			cell := ff.cells[y][x]
+			fuel := ff.fuel[y][x]
+
+			switch {
+			case cell.burning > 0:
+				cell.burning--
+				if cell.burning == 0 {
+					cell.tree = false
+					ff.burnOut(cell.fire)
+				}
+			case cell.tree:
+				if fire, ok := ff.burningNeighbor(x, y); ok && rand.Float64() < fuel.spread {
+					cell = ff.ignite(cell, fire, fuel)
+				} else if rand.Float64() < ff.params.Lightning {
+					ff.nextFire++
+					cell = ff.ignite(cell, ff.nextFire, fuel)
+				}
+			default:
+				cell.tree = rand.Float64() < ff.params.Regrowth*fuel.load
+			}
+			ff.next[y][x] = cell
+		}
+	}
+	ff.cells, ff.next = ff.next, ff.cells
+	return nil
+}
+
+func (ff *ForestFire) ignite(cell fireCell, fire int, fuel fireFuel) fireCell {
+	cell.fire = fire
+	cell.burning = 1 + int(fuel.load*float64(maxBurnSteps-1))
+	ff.fireSize[fire]++
+	ff.fireLeft[fire]++
+	return cell
+}
+
+// burnOut records a fire in the size distribution once its last cell is out.
+func (ff *ForestFire) burnOut(fire int) {
+	ff.fireLeft[fire]--
+	if ff.fireLeft[fire] > 0 {
+		return
+	}
+	ff.fireSizes.Add(ff.fireSize[fire])
+	delete(ff.fireLeft, fire)
+	delete(ff.fireSize, fire)
+}
+
+// burningNeighbor reports a neighbor that was already burning at the start of
+// the update, so a fire spreads one ring per update.
+func (ff *ForestFire) burningNeighbor(x, y int) (int, bool) {
+	for _, d := range ff.grid.directions() {
+		nx, ny := x+d.x, y+d.y
+		if nx >= 0 && ny >= 0 && nx < ff.grid.width && ny < ff.grid.height {
+			if n := ff.cells[ny][nx]; n.burning > 0 {
+				return n.fire, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// FireSizes is the distribution of the number of trees burnt per fire.
+func (ff *ForestFire) FireSizes() *LogHistogram {
+	return &ff.fireSizes
+}
+
+func (ff *ForestFire) ExportFireSizes(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ff.fireSizes.WriteCSV(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (ff *ForestFire) cellColor(x, y int) color.Color {
+	cell := ff.cells[y][x]
+	switch {
+	case cell.burning > 0:
+		return color.RGBA{255, 69, 0, 255}
+	case cell.tree:
+		return color.RGBA{0, 100, 0, 255}
+	default:
+		return ff.grid.cells[y][x]
+	}
+}
+
+func (ff *ForestFire) Draw(screen *ebiten.Image) {
+	for y := 0; y < ff.grid.height; y++ {
+		for x := 0; x < ff.grid.width; x++ {
+			ebitenutil.DrawRect(screen, float64(x*cellSize), float64(y*cellSize), cellSize, cellSize, ff.cellColor(x, y))
+		}
+	}
+
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("Fires: %d  Mean size: %.1f  Largest: %d", ff.fireSizes.Count(), ff.fireSizes.Mean(), ff.fireSizes.Max()))
+	if ff.IsPaused() {
+		ebitenutil.DebugPrintAt(screen, "Paused", screen.Bounds().Dx()/2-30, screen.Bounds().Dy()/2)
+	}
+}
+
+func (ff *ForestFire) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return ff.grid.width * cellSize, ff.grid.height * cellSize
+}
@@ -0,0 +1,190 @@
+package simulation
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+type ReactionField int
+
+const (
+	FieldU ReactionField = iota
+	FieldV
+)
+
+type GrayScottParams struct {
+	Name  string
+	Feed  float64 // Rate at which U is replenished
+	Kill  float64 // Rate at which V is removed
+	DiffU float64 // Diffusion coefficient of U
+	DiffV float64 // Diffusion coefficient of V
+}
+
+var (
+	MitosisPreset = GrayScottParams{Name: "Mitosis", Feed: 0.0367, Kill: 0.0649, DiffU: 0.2097, DiffV: 0.105}
+	CoralPreset   = GrayScottParams{Name: "Coral", Feed: 0.0545, Kill: 0.062, DiffU: 0.2097, DiffV: 0.105}
+	SpotsPreset   = GrayScottParams{Name: "Spots", Feed: 0.035, Kill: 0.065, DiffU: 0.2097, DiffV: 0.105}
+	WormsPreset   = GrayScottParams{Name: "Worms", Feed: 0.078, Kill: 0.061, DiffU: 0.2097, DiffV: 0.105}
+)
+
+func GetGrayScottPresets() []GrayScottParams {
+	return []GrayScottParams{
+		MitosisPreset,
+		CoralPreset,
+		SpotsPreset,
+		WormsPreset,
+	}
+}
+
+type GrayScott struct {
+	BaseSimulation
+	width          int
+	height         int
+	params         GrayScottParams
+	preset         int // Index into GetGrayScottPresets, -1 for custom params
+	u, v           []float64
+	nextU, nextV   []float64
+	stepsPerUpdate int
+	seedRadius     int
+	shown          ReactionField
+	colormap       Colormap
+	renderer       *fieldRenderer
+}
+
+func NewGrayScott(width, height int, params GrayScottParams, shown ReactionField, cmap Colormap) *GrayScott {
+	gs := &GrayScott{
+		width:          width,
+		height:         height,
+		params:         params,
+		u:              make([]float64, width*height),
+		v:              make([]float64, width*height),
+		nextU:          make([]float64, width*height),
+		nextV:          make([]float64, width*height),
+		stepsPerUpdate: 20, // The patterns evolve slowly, so run several steps per frame
+		seedRadius:     4,
+		shown:          shown,
+		colormap:       cmap,
+		renderer:       newFieldRenderer(width, height),
+		preset:         -1,
+	}
+	for i, preset := range GetGrayScottPresets() {
+		if preset == params {
+			gs.preset = i
+		}
+	}
+
+	gs.reset()
+	return gs
+}
+
+// reset fills the domain with U and drops a few random patches of V.
+func (gs *GrayScott) reset() {
+	for i := range gs.u {
+		gs.u[i] = 1
+		gs.v[i] = 0
+	}
+	for p := 0; p < 10; p++ {
+		gs.seed(rand.Intn(gs.width), rand.Intn(gs.height))
+	}
+}
+
+func (gs *GrayScott) seed(cx, cy int) {
+	r := gs.seedRadius
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if dx*dx+dy*dy > r*r {
+				continue
+			}
+			x := (cx + dx + gs.width) % gs.width
+			y := (cy + dy + gs.height) % gs.height
+			gs.u[y*gs.width+x] = 0.5
+			gs.v[y*gs.width+x] = 0.25
+		}
+	}
+}
+
+// laplacian uses the 3x3 stencil with weight 0.2 on edges and 0.05 on corners,
+// wrapping around the edges.
+func (gs *GrayScott) laplacian(field []float64, x, y int) float64 {
+	xl := (x - 1 + gs.width) % gs.width
+	xr := (x + 1) % gs.width
+	yu := (y - 1 + gs.height) % gs.height
+	yd := (y + 1) % gs.height
+	w := gs.width
+
+	return -field[y*w+x] +
+		0.2*(field[y*w+xl]+field[y*w+xr]+field[yu*w+x]+field[yd*w+x]) +
+		0.05*(field[yu*w+xl]+field[yu*w+xr]+field[yd*w+xl]+field[yd*w+xr])
+}
+
+func (gs *GrayScott) step() {
+	p := gs.params
+	for y := 0; y < gs.height; y++ {
+		for x := 0; x < gs.width; x++ {
+			i := y*gs.width + x
+			u, v := gs.u[i], gs.v[i]
+			uvv := u * v * v
+			gs.nextU[i] = clamp01(u + p.DiffU*gs.laplacian(gs.u, x, y) - uvv + p.Feed*(1-u))
+			gs.nextV[i] = clamp01(v + p.DiffV*gs.laplacian(gs.v, x, y) + uvv - (p.Feed+p.Kill)*v)
+		}
+	}
+	gs.u, gs.nextU = gs.nextU, gs.u
+	gs.v, gs.nextV = gs.nextV, gs.v
+}
+
+func (gs *GrayScott) Update() error {
+	gs.UpdatePauseState()
+
+	// Right-click to seed V under the cursor, even while paused
+	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight) {
+		x, y := ebiten.CursorPosition()
+		gridX, gridY := x/cellSize, y/cellSize
+		if gridX >= 0 && gridX < gs.width && gridY >= 0 && gridY < gs.height {
+			gs.seed(gridX, gridY)
+		}
+	}
+
+	// Press U or V to choose which concentration is displayed
+	if ebiten.IsKeyPressed(ebiten.KeyU) {
+		gs.shown = FieldU
+	} else if ebiten.IsKeyPressed(ebiten.KeyV) {
+		gs.shown = FieldV
+	}
+
+	// Press P to cycle through the presets, keeping the current pattern
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		presets := GetGrayScottPresets()
+		gs.preset = (gs.preset + 1) % len(presets)
+		gs.params = presets[gs.preset]
+	}
+
+	if gs.IsPaused() {
+		return nil
+	}
+
+	for i := 0; i < gs.stepsPerUpdate; i++ {
+		gs.step()
+	}
+	return nil
+}
+
+func (gs *GrayScott) Draw(screen *ebiten.Image) {
+	field, name := gs.v, "V"
+	if gs.shown == FieldU {
+		field, name = gs.u, "U"
+	}
+	gs.renderer.draw(screen, field, gs.colormap)
+
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("%s  F: %.4f  k: %.4f  showing %s", gs.params.Name, gs.params.Feed, gs.params.Kill, name))
+	if gs.IsPaused() {
+		ebitenutil.DebugPrintAt(screen, "Paused", screen.Bounds().Dx()/2-30, screen.Bounds().Dy()/2)
+	}
+}
+
+func (gs *GrayScott) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return gs.width * cellSize, gs.height * cellSize
+}
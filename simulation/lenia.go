@@ -0,0 +1,166 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+type GrowthFunc int
+
+const (
+	GaussianGrowth GrowthFunc = iota
+	PolynomialGrowth
+	StepGrowth
+)
+
+type LeniaParams struct {
+	Radius float64   // Kernel radius in cells
+	Peaks  []float64 // Heights of the concentric kernel rings, from the inside out
+	Mu     float64   // Potential at which growth is highest
+	Sigma  float64   // Width of the growth function around Mu
+	Dt     float64   // Fraction of the growth applied per update
+	Growth GrowthFunc
+}
+
+var (
+	OrbiumParams = LeniaParams{
+		Radius: 13,
+		Peaks:  []float64{1},
+		Mu:     0.15,
+		Sigma:  0.015,
+		Dt:     0.1,
+		Growth: GaussianGrowth,
+	}
+
+	GeminiumParams = LeniaParams{
+		Radius: 18,
+		Peaks:  []float64{0.5, 1, 0.667},
+		Mu:     0.26,
+		Sigma:  0.036,
+		Dt:     0.1,
+		Growth: GaussianGrowth,
+	}
+)
+
+type Lenia struct {
+	BaseSimulation
+	width     int
+	height    int
+	params    LeniaParams
+	world     []float64
+	potential []float64
+	conv      *fftConvolver
+	kernel    []complex128
+	colormap  Colormap
+	renderer  *fieldRenderer
+}
+
+func NewLenia(width, height int, params LeniaParams, cmap Colormap) *Lenia {
+	l := &Lenia{
+		width:     width,
+		height:    height,
+		params:    params,
+		world:     make([]float64, width*height),
+		potential: make([]float64, width*height),
+		conv:      newFFTConvolver(width, height),
+		colormap:  cmap,
+		renderer:  newFieldRenderer(width, height),
+	}
+	l.kernel = l.conv.kernelSpectrum(radialKernel(width, height, params.Radius, l.kernelShell))
+
+	l.seed()
+	return l
+}
+
+// kernelShell is the ring profile of the kernel: each peak gets one ring with
+// the smooth exponential bump used by the reference implementation.
+func (l *Lenia) kernelShell(d float64) float64 {
+	r := d / l.params.Radius
+	if r >= 1 {
+		return 0
+	}
+
+	br := float64(len(l.params.Peaks)) * r
+	ring := int(br)
+	frac := br - float64(ring)
+	if frac <= 0 {
+		return 0
+	}
+	return l.params.Peaks[ring] * math.Exp(4-1/(frac*(1-frac)))
+}
+
+func (l *Lenia) growth(u float64) float64 {
+	mu, sigma := l.params.Mu, l.params.Sigma
+	switch l.params.Growth {
+	case PolynomialGrowth:
+		d := u - mu
+		if math.Abs(d) >= 3*sigma {
+			return -1
+		}
+		return 2*math.Pow(1-d*d/(9*sigma*sigma), 4) - 1
+	case StepGrowth:
+		if math.Abs(u-mu) <= sigma {
+			return 1
+		}
+		return -1
+	default:
+		d := u - mu
+		return 2*math.Exp(-d*d/(2*sigma*sigma)) - 1
+	}
+}
+
+// seed clears the world and drops a few patches of random noise, each about
+// the size of the kernel, which is usually enough for creatures to emerge.
+func (l *Lenia) seed() {
+	for i := range l.world {
+		l.world[i] = 0
+	}
+
+	size := int(l.params.Radius * 2)
+	for p := 0; p < 6; p++ {
+		cx, cy := rand.Intn(l.width), rand.Intn(l.height)
+		for dy := 0; dy < size; dy++ {
+			for dx := 0; dx < size; dx++ {
+				x := (cx + dx) % l.width
+				y := (cy + dy) % l.height
+				l.world[y*l.width+x] = rand.Float64()
+			}
+		}
+	}
+}
+
+func (l *Lenia) Update() error {
+	l.UpdatePauseState()
+	if l.IsPaused() {
+		return nil
+	}
+
+	// Right-click to reseed the world
+	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight) {
+		l.seed()
+	}
+
+	l.conv.load(l.world)
+	l.conv.apply(l.kernel, l.potential)
+	for i, u := range l.potential {
+		l.world[i] = clamp01(l.world[i] + l.params.Dt*l.growth(u))
+	}
+	return nil
+}
+
+func (l *Lenia) Draw(screen *ebiten.Image) {
+	l.renderer.draw(screen, l.world, l.colormap)
+
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("R: %.0f  mu: %.3f  sigma: %.3f  dt: %.2f", l.params.Radius, l.params.Mu, l.params.Sigma, l.params.Dt))
+	if l.IsPaused() {
+		ebitenutil.DebugPrintAt(screen, "Paused", screen.Bounds().Dx()/2-30, screen.Bounds().Dy()/2)
+	}
+}
+
+func (l *Lenia) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return l.width * cellSize, l.height * cellSize
+}
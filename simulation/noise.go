@@ -0,0 +1,421 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/aquilax/go-perlin"
+)
+
+// NoiseSource is coherent noise over 3D space, roughly in -1..1. Sources
+// combine into a graph: the fractal, warping and arithmetic types below take
+// other sources as inputs.
+type NoiseSource interface {
+	Noise3D(x, y, z float64) float64
+}
+
+// NoiseFunc adapts a function into a NoiseSource.
+type NoiseFunc func(x, y, z float64) float64
+
+func (f NoiseFunc) Noise3D(x, y, z float64) float64 {
+	return f(x, y, z)
+}
+
+// NewPerlinNoise returns Perlin noise summed over octaves, each octave's
+// frequency multiplied by beta and its amplitude divided by alpha.
+func NewPerlinNoise(alpha, beta float64, octaves int, seed int64) NoiseSource {
+	return perlin.NewPerlin(alpha, beta, int32(octaves), seed)
+}
+
+const (
+	primeX         uint64 = 0x5205402B9270C86F
+	primeY         uint64 = 0x598CD327003817B5
+	primeZ         uint64 = 0x5BCC226E9FA0BACB
+	hashMultiplier uint64 = 0x53A3F72DEEC546F5
+)
+
+// hashLattice mixes a seed and an integer lattice point into 64 random bits.
+func hashLattice(seed int64, x, y, z int64) uint64 {
+	h := uint64(seed) ^ uint64(x)*primeX ^ uint64(y)*primeY ^ uint64(z)*primeZ
+	h *= hashMultiplier
+	return h ^ h>>29
+}
+
+// hashUnit maps a hash onto -1..1.
+func hashUnit(h uint64) float64 {
+	return float64(h>>11)/float64(1<<52) - 1
+}
+
+// quintic is the 6t^5 - 15t^4 + 10t^3 fade curve, which has zero first and
+// second derivatives at the lattice points.
+func quintic(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// ValueNoise interpolates random values placed on the integer lattice.
+type ValueNoise struct {
+	Seed int64
+}
+
+func (n ValueNoise) Noise3D(x, y, z float64) float64 {
+	fx, fy, fz := math.Floor(x), math.Floor(y), math.Floor(z)
+	ix, iy, iz := int64(fx), int64(fy), int64(fz)
+	u, v, w := quintic(x-fx), quintic(y-fy), quintic(z-fz)
+
+	corner := func(dx, dy, dz int64) float64 {
+		return hashUnit(hashLattice(n.Seed, ix+dx, iy+dy, iz+dz))
+	}
+	return lerp(
+		lerp(lerp(corner(0, 0, 0), corner(1, 0, 0), u), lerp(corner(0, 1, 0), corner(1, 1, 0), u), v),
+		lerp(lerp(corner(0, 0, 1), corner(1, 0, 1), u), lerp(corner(0, 1, 1), corner(1, 1, 1), u), v),
+		w)
+}
+
+type WorleyMode int
+
+const (
+	// WorleyF1 is the distance to the nearest feature point, giving cells
+	// that are low in the middle and high along their borders
+	WorleyF1 WorleyMode = iota
+	// WorleyF2MinusF1 is the gap between the nearest two feature points,
+	// which is zero on cell borders and draws a network of ridges
+	WorleyF2MinusF1
+)
+
+// WorleyNoise scatters one feature point in every lattice cell and measures
+// distances to the nearest of them.
+type WorleyNoise struct {
+	Seed int64
+	Mode WorleyMode
+}
+
+func (n WorleyNoise) Noise3D(x, y, z float64) float64 {
+	fx, fy, fz := math.Floor(x), math.Floor(y), math.Floor(z)
+	ix, iy, iz := int64(fx), int64(fy), int64(fz)
+	f1, f2 := math.Inf(1), math.Inf(1)
+
+	for dz := int64(-1); dz <= 1; dz++ {
+		for dy := int64(-1); dy <= 1; dy++ {
+			for dx := int64(-1); dx <= 1; dx++ {
+				cx, cy, cz := ix+dx, iy+dy, iz+dz
+				h := hashLattice(n.Seed, cx, cy, cz)
+				// Three independent offsets within the cell from one hash
+				px := float64(cx) + float64(h&0xFFFFF)/0x100000
+				py := float64(cy) + float64(h>>20&0xFFFFF)/0x100000
+				pz := float64(cz) + float64(h>>40&0xFFFFF)/0x100000
+
+				d := math.Sqrt((px-x)*(px-x) + (py-y)*(py-y) + (pz-z)*(pz-z))
+				if d < f1 {
+					f1, f2 = d, f1
+				} else if d < f2 {
+					f2 = d
+				}
+			}
+		}
+	}
+
+	if n.Mode == WorleyF2MinusF1 {
+		return math.Min(2*(f2-f1), 2) - 1
+	}
+	return math.Min(2*f1, 2) - 1
+}
+
+// openSimplexGradients are the twelve edge midpoints of a cube, each picked
+// equally often.
+var openSimplexGradients = [12][3]float64{
+	{1, 1, 0}, {-1, 1, 0}, {1, -1, 0}, {-1, -1, 0},
+	{1, 0, 1}, {-1, 0, 1}, {1, 0, -1}, {-1, 0, -1},
+	{0, 1, 1}, {0, -1, 1}, {0, 1, -1}, {0, -1, -1},
+}
+
+const (
+	openSimplexRadius   = 0.6 // Squared radius of each lattice point's influence
+	openSimplexSeedFlip = 0x52D547B2E96ED629
+)
+
+// openSimplexNormalizer scales the raw sum of contributions, which sampling
+// millions of points puts within about ±0.0306. Dividing by a little more
+// keeps the result safely inside -1 to 1, at about ±0.89.
+const openSimplexNormalizer = 1 / 0.0345
+
+// OpenSimplexNoise follows OpenSimplex2: a rotated body-centered cubic
+// lattice, seen as two interleaved cubic lattices, whose points each
+// contribute a gradient falling off smoothly within a fixed radius. It avoids
+// the axis-aligned artifacts of Perlin noise.
+type OpenSimplexNoise struct {
+	Seed int64
+}
+
+func (n OpenSimplexNoise) Noise3D(x, y, z float64) float64 {
+	// Rotate so the lattice's main diagonal is no longer lined up with the
+	// axes and the visible 2D slices look isotropic
+	r := (2.0 / 3.0) * (x + y + z)
+	xr, yr, zr := r-x, r-y, r-z
+
+	xb, yb, zb := math.Round(xr), math.Round(yr), math.Round(zr)
+	xi, yi, zi := xr-xb, yr-yb, zr-zb
+	ix, iy, iz := int64(xb), int64(yb), int64(zb)
+
+	// Each sign is -1 for a positive offset and 1 for a negative one
+	sign := func(v float64) float64 {
+		if v > 0 {
+			return -1
+		}
+		return 1
+	}
+	sx, sy, sz := sign(xi), sign(yi), sign(zi)
+	ax, ay, az := -sx*xi, -sy*yi, -sz*zi
+
+	seed := n.Seed
+	contribution := func(a float64, px, py, pz int64, dx, dy, dz float64) float64 {
+		g := openSimplexGradients[(hashLattice(seed, px, py, pz)>>32)%uint64(len(openSimplexGradients))]
+		return a * a * a * a * (g[0]*dx + g[1]*dy + g[2]*dz)
+	}
+
+	value := 0.0
+	a := openSimplexRadius - xi*xi - yi*yi - zi*zi
+	for lattice := 0; ; lattice++ {
+		if a > 0 {
+			value += contribution(a, ix, iy, iz, xi, yi, zi)
+		}
+
+		// The second closest point of this lattice lies along the axis with
+		// the largest offset
+		switch {
+		case ax >= ay && ax >= az:
+			if b := a + 2*ax - 1; b > 0 {
+				value += contribution(b, ix-int64(sx), iy, iz, xi+sx, yi, zi)
+			}
+		case ay > ax && ay >= az:
+			if b := a + 2*ay - 1; b > 0 {
+				value += contribution(b, ix, iy-int64(sy), iz, xi, yi+sy, zi)
+			}
+		default:
+			if b := a + 2*az - 1; b > 0 {
+				value += contribution(b, ix, iy, iz-int64(sz), xi, yi, zi+sz)
+			}
+		}
+
+		if lattice == 1 {
+			break
+		}
+
+		// Move to the other lattice, offset by half a cell on every axis
+		ax, ay, az = 0.5-ax, 0.5-ay, 0.5-az
+		xi, yi, zi = sx*ax, sy*ay, sz*az
+		a += (0.75 - ax) - (ay + az)
+		if sx < 0 {
+			ix++
+		}
+		if sy < 0 {
+			iy++
+		}
+		if sz < 0 {
+			iz++
+		}
+		sx, sy, sz = -sx, -sy, -sz
+		seed ^= openSimplexSeedFlip
+	}
+	return value * openSimplexNormalizer
+}
+
+// Fractal sums octaves of its source (fractional Brownian motion), each at
+// Lacunarity times the previous frequency and Persistence times the previous
+// amplitude, divided by the total amplitude to stay within the source's
+// range.
+type Fractal struct {
+	Source      NoiseSource
+	Octaves     int
+	Lacunarity  float64
+	Persistence float64
+}
+
+func (f Fractal) Noise3D(x, y, z float64) float64 {
+	sum, amplitude, total := 0.0, 1.0, 0.0
+	for i := 0; i < f.Octaves; i++ {
+		sum += amplitude * f.Source.Noise3D(x, y, z)
+		total += amplitude
+		x, y, z = x*f.Lacunarity, y*f.Lacunarity, z*f.Lacunarity
+		amplitude *= f.Persistence
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / total
+}
+
+// Billow folds every octave with an absolute value, turning the zero
+// crossings into creases between rounded lumps, like clouds or hills.
+type Billow struct {
+	Source      NoiseSource
+	Octaves     int
+	Lacunarity  float64
+	Persistence float64
+}
+
+func (b Billow) Noise3D(x, y, z float64) float64 {
+	sum, amplitude, total := 0.0, 1.0, 0.0
+	for i := 0; i < b.Octaves; i++ {
+		sum += amplitude * (2*math.Abs(b.Source.Noise3D(x, y, z)) - 1)
+		total += amplitude
+		x, y, z = x*b.Lacunarity, y*b.Lacunarity, z*b.Lacunarity
+		amplitude *= b.Persistence
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / total
+}
+
+// RidgedMultifractal is Musgrave's ridged multifractal: each octave is
+// inverted into sharp ridges and weighted by the octave before it, so
+// detail piles up on the ridges while valleys stay smooth.
+type RidgedMultifractal struct {
+	Source     NoiseSource
+	Octaves    int
+	Lacunarity float64
+	Offset     float64 // Usually 1; shifts the folded signal so ridges peak at Offset
+	Gain       float64 // Usually 2; how strongly each octave weights the next
+}
+
+func (r RidgedMultifractal) Noise3D(x, y, z float64) float64 {
+	sum, weight, total := 0.0, 1.0, 0.0
+	amplitude := 1.0
+	for i := 0; i < r.Octaves; i++ {
+		signal := r.Offset - math.Abs(r.Source.Noise3D(x, y, z))
+		signal *= signal * weight
+		weight = clamp01(signal * r.Gain)
+
+		sum += signal * amplitude
+		total += r.Offset * r.Offset * amplitude
+		x, y, z = x*r.Lacunarity, y*r.Lacunarity, z*r.Lacunarity
+		amplitude /= r.Lacunarity
+	}
+	if total == 0 {
+		return 0
+	}
+	return 2*sum/total - 1
+}
+
+// DomainWarp samples its source at a point displaced by two other sources,
+// which drags the features into swirls and folds.
+type DomainWarp struct {
+	Source   NoiseSource
+	WarpX    NoiseSource
+	WarpY    NoiseSource
+	Strength float64
+}
+
+func (d DomainWarp) Noise3D(x, y, z float64) float64 {
+	wx := d.WarpX.Noise3D(x, y, z)
+	wy := d.WarpY.Noise3D(x, y, z)
+	return d.Source.Noise3D(x+d.Strength*wx, y+d.Strength*wy, z)
+}
+
+// ScaleBias multiplies its source by Scale and adds Bias.
+type ScaleBias struct {
+	Source NoiseSource
+	Scale  float64
+	Bias   float64
+}
+
+func (s ScaleBias) Noise3D(x, y, z float64) float64 {
+	return s.Source.Noise3D(x, y, z)*s.Scale + s.Bias
+}
+
+// Frequency samples its source at Scale times the coordinates.
+type Frequency struct {
+	Source NoiseSource
+	Scale  float64
+}
+
+func (f Frequency) Noise3D(x, y, z float64) float64 {
+	return f.Source.Noise3D(x*f.Scale, y*f.Scale, z*f.Scale)
+}
+
+// Blend mixes its sources in proportion to their weights.
+type Blend struct {
+	Sources []NoiseSource
+	Weights []float64
+}
+
+func (b Blend) Noise3D(x, y, z float64) float64 {
+	sum, total := 0.0, 0.0
+	for i, s := range b.Sources {
+		w := 1.0
+		if i < len(b.Weights) {
+			w = b.Weights[i]
+		}
+		sum += w * s.Noise3D(x, y, z)
+		total += math.Abs(w)
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / total
+}
+
+// Multiply multiplies its sources together, e.g. to mask one with another.
+type Multiply []NoiseSource
+
+func (m Multiply) Noise3D(x, y, z float64) float64 {
+	product := 1.0
+	for _, s := range m {
+		product *= s.Noise3D(x, y, z)
+	}
+	return product
+}
+
+type NoiseType int
+
+const (
+	PerlinNoiseType NoiseType = iota
+	OpenSimplexNoiseType
+	ValueNoiseType
+	WorleyNoiseType
+	RidgedNoiseType
+	BillowNoiseType
+	WarpedNoiseType
+)
+
+var noiseTypeNames = []string{"Perlin", "OpenSimplex", "Value", "Worley", "Ridged", "Billow", "Domain warped"}
+
+func (n NoiseType) String() string {
+	if n >= 0 && int(n) < len(noiseTypeNames) {
+		return noiseTypeNames[n]
+	}
+	return fmt.Sprintf("NoiseType(%d)", int(n))
+}
+
+// NewNoise builds a ready-made graph of the given type. Octave counts and
+// the per-octave frequency and amplitude factors follow go-perlin's
+// convention: frequency is multiplied by beta and amplitude divided by
+// alpha.
+func NewNoise(kind NoiseType, alpha, beta float64, octaves int, seed int64) NoiseSource {
+	persistence := 1 / alpha
+	switch kind {
+	case OpenSimplexNoiseType:
+		return Fractal{OpenSimplexNoise{seed}, octaves, beta, persistence}
+	case ValueNoiseType:
+		return Fractal{ValueNoise{seed}, octaves, beta, persistence}
+	case WorleyNoiseType:
+		return Fractal{WorleyNoise{seed, WorleyF1}, octaves, beta, persistence}
+	case RidgedNoiseType:
+		return RidgedMultifractal{OpenSimplexNoise{seed}, octaves, beta, 1, 2}
+	case BillowNoiseType:
+		return Billow{OpenSimplexNoise{seed}, octaves, beta, persistence}
+	case WarpedNoiseType:
+		return DomainWarp{
+			Source:   NewPerlinNoise(alpha, beta, octaves, seed),
+			WarpX:    Fractal{OpenSimplexNoise{seed + 1}, 2, beta, persistence},
+			WarpY:    Fractal{OpenSimplexNoise{seed + 2}, 2, beta, persistence},
+			Strength: 1.5,
+		}
+	default:
+		return NewPerlinNoise(alpha, beta, octaves, seed)
+	}
+}
@@ -2,31 +2,120 @@ package simulation
 
 import (
 	"fmt"
+	"image/color"
+	"math"
+	"math/rand"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 )
 
+var walkerColor = color.RGBA{255, 40, 40, 255}
+
+type walker struct {
+	x, y   int
+	effort float64 // Effort gathered towards the cost of the next move
+}
+
 type randomWalker struct {
 	BaseSimulation
-	grid *Grid
+	grid      *Grid
+	terrain   *TerrainSubstrate // nil for open ground everywhere
+	walkers   []*walker
+	occupancy [][]int // Walkers in each cell
 }
 
+// NewrandomWalker returns an empty black grid without walkers.
 func NewrandomWalker(width, height int) *randomWalker {
-	fmt.Println("Creating random walker")
 	grid := NewGrid(width, height)
+	for y := range grid.cells {
+		for x := range grid.cells[y] {
+			grid.cells[y][x] = color.Black
+		}
+	}
+	return newRandomWalker(grid, nil, 0)
+}
 
-	rw := &randomWalker{grid: grid}
+// NewTerrainWalker lets walkers roam a terrain. Each update a walker tries a
+// random neighbor; unless the cell is impassable or already holds as many
+// walkers as its capacity, the walker gathers one unit of effort towards it
+// and enters once it has gathered the cell's move cost.
+func NewTerrainWalker(terrain *TerrainSubstrate, walkers int) *randomWalker {
+	return newRandomWalker(terrain.Terrain().grid, terrain, walkers)
+}
 
+func newRandomWalker(grid *Grid, terrain *TerrainSubstrate, walkers int) *randomWalker {
+	rw := &randomWalker{
+		grid:      grid,
+		terrain:   terrain,
+		occupancy: make([][]int, grid.height),
+	}
+	for y := range rw.occupancy {
+		rw.occupancy[y] = make([]int, grid.width)
+	}
+
+	// Drop walkers on random cells with room for them, giving up on any
+	// that can't find one
+	for i := 0; i < walkers; i++ {
+		for attempt := 0; attempt < 100; attempt++ {
+			x, y := rand.Intn(grid.width), rand.Intn(grid.height)
+			if rw.canEnter(x, y) {
+				rw.walkers = append(rw.walkers, &walker{x: x, y: y})
+				rw.occupancy[y][x]++
+				break
+			}
+		}
+	}
 	return rw
 }
 
-func (rw *randomWalker) update() error {
+func (rw *randomWalker) traits(x, y int) TerrainTraits {
+	if rw.terrain == nil {
+		if x < 0 || y < 0 || x >= rw.grid.width || y >= rw.grid.height {
+			return TerrainTraits{}
+		}
+		return openTraits
+	}
+	return rw.terrain.Traits(x, y)
+}
+
+// canEnter reports whether a walker may step onto (x, y) right now.
+func (rw *randomWalker) canEnter(x, y int) bool {
+	traits := rw.traits(x, y)
+	return traits.Passable && rw.occupancy[y][x] < traits.Capacity
+}
+
+func (rw *randomWalker) Update() error {
+	rw.UpdatePauseState()
+	if rw.IsPaused() {
+		return nil
+	}
+
+	for _, w := range rw.walkers {
+		dir := d8Offsets[rand.Intn(len(d8Offsets))]
+		nx, ny := w.x+dir[0], w.y+dir[1]
+		if !rw.canEnter(nx, ny) {
+			continue
+		}
+		// Effort only builds up towards a cell the walker can enter, and
+		// never beyond what that cell costs
+		cost := rw.traits(nx, ny).MoveCost
+		w.effort = math.Min(w.effort+1, cost)
+		if w.effort >= cost {
+			rw.occupancy[w.y][w.x]--
+			rw.occupancy[ny][nx]++
+			w.x, w.y, w.effort = nx, ny, 0
+		}
+	}
 	return nil
 }
 
 func (rw *randomWalker) Draw(screen *ebiten.Image) {
 	rw.grid.Draw(screen, cellSize)
+	for _, w := range rw.walkers {
+		ebitenutil.DrawRect(screen, float64(w.x*cellSize)+1, float64(w.y*cellSize)+1, cellSize-2, cellSize-2, walkerColor)
+	}
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Walkers: %d", len(rw.walkers)), 10, 10)
 
 	if rw.IsPaused() {
 		ebitenutil.DebugPrintAt(screen, "Paused", screen.Bounds().Dx()/2-30, screen.Bounds().Dy()/2)
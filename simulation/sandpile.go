@@ -0,0 +1,247 @@
+package simulation
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+const toppleThreshold = 4
+
+type DropMode int
+
+const (
+	DropCenter DropMode = iota
+	DropRandom
+)
+
+func (m DropMode) String() string {
+	if m == DropRandom {
+		return "random"
+	}
+	return "center"
+}
+
+var sandpileColors = []color.Color{
+	color.Black,
+	color.RGBA{0, 0, 255, 255},
+	color.RGBA{0, 255, 255, 255},
+	color.RGBA{255, 215, 0, 255},
+}
+
+type Sandpile struct {
+	BaseSimulation
+	grid          *Grid
+	heights       [][]int
+	mode          DropMode
+	grainsPerDrop int
+	avalancheSize LogHistogram // Topplings per drop
+	avalancheTime LogHistogram // Waves of parallel topplings per drop
+	avalancheArea LogHistogram // Distinct cells toppled per drop
+	grainsDropped int
+	lastAvalanche int
+	toppledAtDrop [][]int // Drop number at which each cell last toppled, used to measure area
+}
+
+type cellPos struct {
+	x, y int
+}
+
+func NewSandpile(width, height int, mode DropMode) *Sandpile {
+	sp := &Sandpile{
+		grid:          NewGrid(width, height),
+		heights:       make([][]int, height),
+		mode:          mode,
+		grainsPerDrop: 50, // A single grain per frame is far too slow to watch
+		toppledAtDrop: make([][]int, height),
+	}
+	for y := range sp.heights {
+		sp.heights[y] = make([]int, width)
+		sp.toppledAtDrop[y] = make([]int, width)
+	}
+	return sp
+}
+
+// Drop adds a grain at (x, y) and relaxes the pile, recording the avalanche.
+func (sp *Sandpile) Drop(x, y int) {
+	sp.grainsDropped++
+	sp.heights[y][x]++
+
+	if sp.heights[y][x] < toppleThreshold {
+		return
+	}
+
+	size, duration, area := sp.relax([]cellPos{{x, y}})
+	if size > 0 {
+		sp.avalancheSize.Add(size)
+		sp.avalancheTime.Add(duration)
+		sp.avalancheArea.Add(area)
+		sp.lastAvalanche = size
+	}
+}
+
+// relax topples the unstable cells in waves until the pile is stable, every
+// wave toppling all cells that went over the threshold in the previous one.
+// Grains pushed over the edge are lost.
+func (sp *Sandpile) relax(unstable []cellPos) (size, duration, area int) {
+	for len(unstable) > 0 {
+		duration++
+		var next []cellPos
+		for _, c := range unstable {
+			h := sp.heights[c.y][c.x]
+			if h < toppleThreshold {
+				continue
+			}
+			topples := h / toppleThreshold
+			sp.heights[c.y][c.x] -= topples * toppleThreshold
+			size += topples
+			if sp.toppledAtDrop[c.y][c.x] != sp.grainsDropped {
+				sp.toppledAtDrop[c.y][c.x] = sp.grainsDropped
+				area++
+			}
+
+			for _, d := range []cellPos{{0, -1}, {-1, 0}, {1, 0}, {0, 1}} {
+				nx, ny := c.x+d.x, c.y+d.y
+				if nx >= 0 && ny >= 0 && nx < sp.grid.width && ny < sp.grid.height {
+					before := sp.heights[ny][nx]
+					sp.heights[ny][nx] += topples
+					if before < toppleThreshold && sp.heights[ny][nx] >= toppleThreshold {
+						next = append(next, cellPos{nx, ny})
+					}
+				}
+			}
+		}
+		unstable = next
+	}
+	return size, duration, area
+}
+
+// LoadIdentity replaces the pile with the identity element of the sandpile
+// group for the current grid size.
+func (sp *Sandpile) LoadIdentity() {
+	identity := SandpileIdentity(sp.grid.width, sp.grid.height)
+	for y := range sp.heights {
+		copy(sp.heights[y], identity[y])
+	}
+}
+
+// SandpileIdentity computes the identity of the sandpile group on a
+// width x height grid as (6 - (6)°)°, where 6 is the pile with six grains on
+// every cell and ° denotes stabilization.
+func SandpileIdentity(width, height int) [][]int {
+	sp := NewSandpile(width, height, DropCenter)
+	all := make([]cellPos, 0, width*height)
+	for y := range sp.heights {
+		for x := range sp.heights[y] {
+			sp.heights[y][x] = 6
+			all = append(all, cellPos{x, y})
+		}
+	}
+	sp.relax(all)
+	for y := range sp.heights {
+		for x := range sp.heights[y] {
+			sp.heights[y][x] = 6 - sp.heights[y][x]
+		}
+	}
+	sp.relax(all)
+	return sp.heights
+}
+
+// AvalancheSizes, AvalancheDurations and AvalancheAreas are the per-drop
+// distributions; use WriteCSV on them to export a log-log histogram.
+func (sp *Sandpile) AvalancheSizes() *LogHistogram {
+	return &sp.avalancheSize
+}
+
+func (sp *Sandpile) AvalancheDurations() *LogHistogram {
+	return &sp.avalancheTime
+}
+
+func (sp *Sandpile) AvalancheAreas() *LogHistogram {
+	return &sp.avalancheArea
+}
+
+// ExportAvalanches writes the size, duration and area histograms as CSV
+// files into dir.
+func (sp *Sandpile) ExportAvalanches(dir string) error {
+	histograms := map[string]*LogHistogram{
+		"avalanche_sizes.csv":     &sp.avalancheSize,
+		"avalanche_durations.csv": &sp.avalancheTime,
+		"avalanche_areas.csv":     &sp.avalancheArea,
+	}
+	for name, h := range histograms {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := h.WriteCSV(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sp *Sandpile) Update() error {
+	sp.UpdatePauseState()
+
+	// Press E to export the avalanche histograms to the working directory
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		if err := sp.ExportAvalanches("."); err != nil {
+			log.Printf("exporting avalanches: %v", err)
+		}
+	}
+
+	// Press M to switch between dropping in the center and on random cells
+	if inpututil.IsKeyJustPressed(ebiten.KeyM) {
+		sp.mode = (sp.mode + 1) % (DropRandom + 1)
+	}
+
+	// Press I to replace the pile with the identity element
+	if inpututil.IsKeyJustPressed(ebiten.KeyI) {
+		sp.LoadIdentity()
+	}
+
+	if sp.IsPaused() {
+		return nil
+	}
+
+	for i := 0; i < sp.grainsPerDrop; i++ {
+		switch sp.mode {
+		case DropRandom:
+			sp.Drop(rand.Intn(sp.grid.width), rand.Intn(sp.grid.height))
+		default:
+			sp.Drop(sp.grid.width/2, sp.grid.height/2)
+		}
+	}
+	return nil
+}
+
+func (sp *Sandpile) Draw(screen *ebiten.Image) {
+	for y := 0; y < sp.grid.height; y++ {
+		for x := 0; x < sp.grid.width; x++ {
+			ebitenutil.DrawRect(screen, float64(x*cellSize), float64(y*cellSize), cellSize, cellSize, sandpileColors[sp.heights[y][x]])
+		}
+	}
+
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("Grains: %d  Avalanches: %d  Mean size: %.1f  Largest: %d  Last: %d",
+		sp.grainsDropped, sp.avalancheSize.Count(), sp.avalancheSize.Mean(), sp.avalancheSize.Max(), sp.lastAvalanche))
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Dropping: %s (M)  Identity: I", sp.mode), 0, 15)
+	if sp.IsPaused() {
+		ebitenutil.DebugPrintAt(screen, "Paused", screen.Bounds().Dx()/2-30, screen.Bounds().Dy()/2)
+	}
+}
+
+func (sp *Sandpile) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return sp.grid.width * cellSize, sp.grid.height * cellSize
+}
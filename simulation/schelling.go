@@ -2,115 +2,461 @@ package simulation
 
 import (
 	"fmt"
+	"image"
 	"image/color"
+	"log"
+	"math"
 	"math/rand"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 )
 
+type SchellingGroup struct {
+	Color      color.Color
+	Proportion float64 // Share of the agent population, relative to the other groups
+	Threshold  float64 // Fraction of like neighbors the group's agents need to be satisfied
+	// ThresholdSpread, when above zero, draws each agent's own threshold from a
+	// normal distribution around Threshold with this standard deviation
+	ThresholdSpread float64
+}
+
+type schellingAgent struct {
+	group     int
+	threshold float64
+	x, y      int
+}
+
+type RelocationStrategy int
+
+const (
+	// RandomVacancy moves an unhappy agent to any empty cell
+	RandomVacancy RelocationStrategy = iota
+	// NearestSatisfyingVacancy moves to the closest empty cell where the agent
+	// would be satisfied, staying put if there is none
+	NearestSatisfyingVacancy
+	// BestUtilityVacancy moves to the empty cell within the search radius with
+	// the highest fraction of like neighbors, if it beats the current one
+	BestUtilityVacancy
+	// SwapWithUnhappy trades places with an unhappy agent of another group
+	SwapWithUnhappy
+)
+
+// swapAttempts bounds how many unhappy partners SwapWithUnhappy samples
+// before giving up for this update.
+const swapAttempts = 10
+
+type UpdateSchedule int
+
+const (
+	// FixedOrder activates every agent once per update, always in the same
+	// order (row-major at the start of the run)
+	FixedOrder UpdateSchedule = iota
+	// RandomOrder activates every agent once per update in a fresh random order
+	RandomOrder
+	// Synchronous lets every agent decide against the same world, then moves
+	// all unhappy agents
+	Synchronous
+	// RandomActivation activates one random agent at a time, as many times as
+	// there are agents, so some act twice and others not at all
+	RandomActivation
+)
+
+type SchellingOption func(*Schelling)
+
+// WithGroups replaces the groups built from the constructor's colors and
+// threshold, allowing any number of groups with their own proportions and
+// tolerances. An empty list is ignored.
+func WithGroups(groups ...SchellingGroup) SchellingOption {
+	return func(s *Schelling) {
+		if len(groups) > 0 {
+			s.groups = groups
+		}
+	}
+}
+
+// WithRelocation selects how unhappy agents choose where to move.
+func WithRelocation(strategy RelocationStrategy) SchellingOption {
+	return func(s *Schelling) {
+		s.relocation = strategy
+	}
+}
+
+// WithSeed makes a run reproducible; without it every run is seeded randomly.
+func WithSeed(seed int64) SchellingOption {
+	return func(s *Schelling) {
+		s.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithEmptyRatio sets the fraction of cells left vacant at the start.
+func WithEmptyRatio(ratio float64) SchellingOption {
+	return func(s *Schelling) {
+		s.emptyRatio = ratio
+	}
+}
+
+// WithNeighborhoodRadius widens the Moore neighborhood agents look at when
+// judging their surroundings; the default radius of 1 is the 8 adjacent cells.
+func WithNeighborhoodRadius(radius int) SchellingOption {
+	return func(s *Schelling) {
+		s.neighborRadius = radius
+	}
+}
+
+// WithSchedule selects the order in which agents act within an update.
+func WithSchedule(schedule UpdateSchedule) SchellingOption {
+	return func(s *Schelling) {
+		s.schedule = schedule
+	}
+}
+
+// WithSearchRadius sets how far BestUtilityVacancy looks for a better cell.
+func WithSearchRadius(radius int) SchellingOption {
+	return func(s *Schelling) {
+		s.searchRadius = radius
+	}
+}
+
+// WithTerrain places the agents on a generated terrain. Schelling keeps one
+// agent per cell, so a cell's capacity only decides whether it is habitable
+// at all; only habitable cells hold agents, the nearest vacancy is judged by
+// distance times the cost of moving there, and empty cells show the terrain.
+// A terrain that isn't the size of the grid is ignored.
+func WithTerrain(terrain *TerrainSubstrate) SchellingOption {
+	return func(s *Schelling) {
+		if terrain.Width() != s.grid.width || terrain.Height() != s.grid.height {
+			log.Printf("schelling: ignoring %dx%d terrain on a %dx%d grid", terrain.Width(), terrain.Height(), s.grid.width, s.grid.height)
+			return
+		}
+		s.terrain = terrain
+	}
+}
+
 type Schelling struct {
 	BaseSimulation
-	grid           *Grid
-	threshold      float64
-	emptyColor     color.Color
-	groupColors    []color.Color
-	stableCounter  int     // Counts how many updates the grid has been stable
-	maxStableCount int     // Maximum number of stable updates before increasing the threshold
-	stableLimit    float64 // The percentage of satisfied agents to consider the grid stable
+	grid            *Grid
+	rng             *rand.Rand
+	agents          [][]*schellingAgent
+	emptyRatio      float64
+	layout          InitialLayout
+	layoutScale     int
+	population      image.Image // Imported initial population, overrides the layout
+	terrain         *TerrainSubstrate
+	neighborRadius  int
+	groups          []SchellingGroup
+	relocation      RelocationStrategy
+	schedule        UpdateSchedule
+	agentList       []*schellingAgent // Every agent in activation order for FixedOrder
+	searchRadius    int
+	vacancies       []cellPos // Every empty cell, so a random destination can be picked in O(1)
+	vacancyIndex    [][]int   // Position of each cell in vacancies, -1 if occupied
+	unhappy         []cellPos // Unhappy agents at the start of the update, for swapping
+	stepCount       int
+	metrics         SchellingMetrics
+	history         []SchellingMetrics // Ring buffer of the last maxMetricsHistory updates
+	historyStart    int                // Index of the oldest entry once history is full
+	thresholdShift  float64            // Added to every agent's threshold, as decided by the threshold policy
+	thresholdPolicy ThresholdPolicy
+	emptyColor      color.Color
+	populations     []int   // Agents per group in the last update
+	satisfied       []int   // Satisfied agents per group in the last update
+	stableCounter   int     // Counts how many updates the grid has been stable
+	stableLimit     float64 // The percentage of satisfied agents to consider the grid stable
 }
 
-func NewSchelling(width, height int, threshold float64, groupColors []color.Color) *Schelling {
+func NewSchelling(width, height int, threshold float64, groupColors []color.Color, opts ...SchellingOption) *Schelling {
 	grid := NewGrid(width, height)
 	sim := &Schelling{
-		grid:           grid,
-		threshold:      threshold,
-		emptyColor:     color.Black,
-		groupColors:    groupColors,
-		maxStableCount: 5, // Number of stable updates before increasing the threshold
-		stableLimit:    1, // Consider grid stable if 95% or more agents are satisfied
+		grid:            grid,
+		rng:             rand.New(rand.NewSource(rand.Int63())),
+		agents:          make([][]*schellingAgent, height),
+		emptyRatio:      0.05,
+		layoutScale:     8,
+		neighborRadius:  1,
+		searchRadius:    5,
+		vacancyIndex:    make([][]int, height),
+		emptyColor:      color.Black,
+		thresholdPolicy: DefaultThresholdPolicy,
+		stableLimit:     0.95, // Consider grid stable if 95% or more agents are satisfied
+	}
+	for y := range sim.agents {
+		sim.agents[y] = make([]*schellingAgent, width)
+		sim.vacancyIndex[y] = make([]int, width)
+		for x := range sim.vacancyIndex[y] {
+			sim.vacancyIndex[y][x] = -1
+		}
+	}
+	for _, c := range groupColors {
+		sim.groups = append(sim.groups, SchellingGroup{Color: c, Proportion: 1, Threshold: threshold})
+	}
+
+	for _, opt := range opts {
+		opt(sim)
 	}
+	sim.populations = make([]int, len(sim.groups))
+	sim.satisfied = make([]int, len(sim.groups))
 
-	sim.randomizeGrid(0.05)
+	sim.populate()
+	sim.tallySatisfaction()
+	sim.metrics = sim.computeMetrics()
 	return sim
 }
 
-func (s *Schelling) randomizeGrid(emptyRatio float64) {
-	for y := range s.grid.cells {
-		for x := range s.grid.cells[y] {
-			if rand.Float64() < emptyRatio {
-				s.grid.cells[y][x] = s.emptyColor
-			} else {
-				s.grid.cells[y][x] = s.groupColors[rand.Intn(len(s.groupColors))]
-			}
+// set places an agent (or nil for an empty cell), keeping the vacancy list
+// and the grid colors used for drawing in sync.
+func (s *Schelling) set(x, y int, agent *schellingAgent) {
+	s.agents[y][x] = agent
+	vacant := s.vacancyIndex[y][x] >= 0
+	if agent == nil {
+		s.grid.cells[y][x] = s.emptyColor
+		if s.terrain != nil {
+			s.grid.cells[y][x] = s.terrain.colorAt(x, y)
+		}
+		if !vacant && s.habitable(x, y) {
+			s.vacancyIndex[y][x] = len(s.vacancies)
+			s.vacancies = append(s.vacancies, cellPos{x, y})
+		}
+	} else {
+		agent.x, agent.y = x, y
+		s.grid.cells[y][x] = s.groups[agent.group].Color
+		if vacant {
+			i := s.vacancyIndex[y][x]
+			last := s.vacancies[len(s.vacancies)-1]
+			s.vacancies[i] = last
+			s.vacancyIndex[last.y][last.x] = i
+			s.vacancies = s.vacancies[:len(s.vacancies)-1]
+			s.vacancyIndex[y][x] = -1
 		}
 	}
 }
 
+// habitable reports whether an agent may live at (x, y), which is anywhere
+// without a terrain.
+func (s *Schelling) habitable(x, y int) bool {
+	return s.terrain == nil || s.terrain.Habitable(x, y)
+}
+
+func (s *Schelling) newAgent(group int) *schellingAgent {
+	g := s.groups[group]
+	threshold := g.Threshold
+	if g.ThresholdSpread > 0 {
+		threshold = clamp01(threshold + s.rng.NormFloat64()*g.ThresholdSpread)
+	}
+	agent := &schellingAgent{group: group, threshold: threshold}
+	s.agentList = append(s.agentList, agent)
+	return agent
+}
+
+// randomGroup picks a group with probability proportional to its proportion.
+func (s *Schelling) randomGroup() int {
+	return s.groupAtFraction(s.rng.Float64())
+}
+
+// groupAtFraction maps f in [0, 1) onto the groups, each group taking a slice
+// of the interval as wide as its share of the proportions.
+func (s *Schelling) groupAtFraction(f float64) int {
+	total := 0.0
+	for _, g := range s.groups {
+		total += g.Proportion
+	}
+	if total <= 0 {
+		return int(f * float64(len(s.groups)))
+	}
+
+	r := f * total
+	for i, g := range s.groups {
+		r -= g.Proportion
+		if r < 0 {
+			return i
+		}
+	}
+	return len(s.groups) - 1
+}
+
 func (s *Schelling) isSatisfied(x, y int) bool {
-	currentColor := s.grid.cells[y][x]
-	if currentColor == s.emptyColor {
+	agent := s.agents[y][x]
+	if agent == nil {
 		return true
 	}
+	return s.wouldBeSatisfied(agent, x, y, x, y)
+}
 
-	likeNeighbors := 0
-	totalNeighbors := 0
+// likeFraction is the fraction of occupied neighbors of (x, y) that belong to
+// group, ignoring the cell at (ignoreX, ignoreY) so that a moving agent
+// doesn't count itself. It is 1 when there are no neighbors.
+func (s *Schelling) likeFraction(group, x, y, ignoreX, ignoreY int) float64 {
+	likeNeighbors, totalNeighbors := s.neighborCounts(group, x, y, ignoreX, ignoreY)
+	if totalNeighbors == 0 {
+		return 1
+	}
+	return float64(likeNeighbors) / float64(totalNeighbors)
+}
 
-	for _, d := range s.grid.directions() {
-		nx, ny := x+d.x, y+d.y
-		if nx >= 0 && ny >= 0 && nx < s.grid.width && ny < s.grid.height {
-			neighborColor := s.grid.cells[ny][nx]
-			if neighborColor != s.emptyColor {
-				totalNeighbors++
-				if neighborColor == currentColor {
-					likeNeighbors++
+func (s *Schelling) neighborCounts(group, x, y, ignoreX, ignoreY int) (likeNeighbors, totalNeighbors int) {
+	r := s.neighborRadius
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			nx, ny := x+dx, y+dy
+			if (dx == 0 && dy == 0) || (nx == ignoreX && ny == ignoreY) {
+				continue
+			}
+			if nx >= 0 && ny >= 0 && nx < s.grid.width && ny < s.grid.height {
+				neighbor := s.agents[ny][nx]
+				if neighbor != nil {
+					totalNeighbors++
+					if neighbor.group == group {
+						likeNeighbors++
+					}
 				}
 			}
 		}
 	}
+	return likeNeighbors, totalNeighbors
+}
 
-	if totalNeighbors == 0 {
+// wouldBeSatisfied reports whether the agent currently at (fromX, fromY)
+// would be satisfied at (x, y).
+func (s *Schelling) wouldBeSatisfied(agent *schellingAgent, x, y, fromX, fromY int) bool {
+	return s.likeFraction(agent.group, x, y, fromX, fromY) >= math.Min(agent.threshold+s.thresholdShift, 1)
+}
+
+// moveAgent relocates the unhappy agent at (x, y) using the configured
+// strategy and reports whether it moved. Agents stay put rather than wait
+// when no destination is available.
+func (s *Schelling) moveAgent(x, y int) bool {
+	agent := s.agents[y][x]
+
+	switch s.relocation {
+	case NearestSatisfyingVacancy:
+		best, bestDist := -1, 0.0
+		for i, v := range s.vacancies {
+			dist := float64((v.x-x)*(v.x-x) + (v.y-y)*(v.y-y))
+			if s.terrain != nil {
+				dist *= s.terrain.MoveCost(v.x, v.y)
+			}
+			if (best < 0 || dist < bestDist) && s.wouldBeSatisfied(agent, v.x, v.y, x, y) {
+				best, bestDist = i, dist
+			}
+		}
+		if best < 0 {
+			return false
+		}
+		s.relocate(x, y, s.vacancies[best])
 		return true
-	}
 
-	return float64(likeNeighbors)/float64(totalNeighbors) >= s.threshold
-}
+	case BestUtilityVacancy:
+		best := cellPos{-1, -1}
+		bestUtility := s.likeFraction(agent.group, x, y, x, y)
+		for dy := -s.searchRadius; dy <= s.searchRadius; dy++ {
+			for dx := -s.searchRadius; dx <= s.searchRadius; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx < 0 || ny < 0 || nx >= s.grid.width || ny >= s.grid.height || s.agents[ny][nx] != nil || !s.habitable(nx, ny) {
+					continue
+				}
+				if utility := s.likeFraction(agent.group, nx, ny, x, y); utility > bestUtility {
+					best, bestUtility = cellPos{nx, ny}, utility
+				}
+			}
+		}
+		if best.x < 0 {
+			return false
+		}
+		s.relocate(x, y, best)
+		return true
+
+	case SwapWithUnhappy:
+		for attempt := 0; attempt < swapAttempts && len(s.unhappy) > 0; attempt++ {
+			p := s.unhappy[s.rng.Intn(len(s.unhappy))]
+			other := s.agents[p.y][p.x]
+			if other == nil || other.group == agent.group || s.isSatisfied(p.x, p.y) {
+				continue
+			}
+			s.set(x, y, other)
+			s.set(p.x, p.y, agent)
+			return true
+		}
+		return false
 
-func (s *Schelling) moveAgent(x, y int) {
-	for {
-		nx, ny := rand.Intn(s.grid.width), rand.Intn(s.grid.height)
-		if s.grid.cells[ny][nx] == s.emptyColor {
-			s.grid.cells[ny][nx] = s.grid.cells[y][x]
-			s.grid.cells[y][x] = s.emptyColor
-			break
+	default:
+		if len(s.vacancies) == 0 {
+			return false
 		}
+		s.relocate(x, y, s.vacancies[s.rng.Intn(len(s.vacancies))])
+		return true
 	}
 }
 
+func (s *Schelling) relocate(x, y int, to cellPos) {
+	s.set(to.x, to.y, s.agents[y][x])
+	s.set(x, y, nil)
+}
+
 func (s *Schelling) Update() error {
 	s.UpdatePauseState()
+
+	// Press E to export the metrics time series to the working directory
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		if err := s.ExportMetrics("schelling_metrics.csv"); err != nil {
+			log.Printf("exporting metrics: %v", err)
+		}
+	}
+
 	if s.IsPaused() {
 		return nil
 	}
 
-	totalAgents := 0
-	satisfiedAgents := 0
+	s.advance()
+	return nil
+}
 
-	for y := range s.grid.cells {
-		for x := range s.grid.cells[y] {
-			if s.grid.cells[y][x] != s.emptyColor {
-				totalAgents++
-				if s.isSatisfied(x, y) {
-					satisfiedAgents++
-				} else {
-					s.moveAgent(x, y)
-				}
+// advance runs one update under the configured schedule and returns how many
+// agents moved.
+func (s *Schelling) advance() int {
+	if s.relocation == SwapWithUnhappy {
+		s.unhappy = s.unhappy[:0]
+		for _, agent := range s.agentList {
+			if !s.isSatisfied(agent.x, agent.y) {
+				s.unhappy = append(s.unhappy, cellPos{agent.x, agent.y})
 			}
 		}
 	}
 
-	// Calculate the satisfaction ratio
-	satisfactionRatio := float64(satisfiedAgents) / float64(totalAgents)
+	moves := 0
+	switch s.schedule {
+	case Synchronous:
+		// Every agent decides against the same world before anyone moves
+		var movers []*schellingAgent
+		for _, agent := range s.agentList {
+			if !s.isSatisfied(agent.x, agent.y) {
+				movers = append(movers, agent)
+			}
+		}
+		s.rng.Shuffle(len(movers), func(i, j int) { movers[i], movers[j] = movers[j], movers[i] })
+		for _, agent := range movers {
+			if s.moveAgent(agent.x, agent.y) {
+				moves++
+			}
+		}
+	case RandomActivation:
+		for i := 0; i < len(s.agentList); i++ {
+			moves += s.activate(s.agentList[s.rng.Intn(len(s.agentList))])
+		}
+	case RandomOrder:
+		order := make([]*schellingAgent, len(s.agentList))
+		copy(order, s.agentList)
+		s.rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		for _, agent := range order {
+			moves += s.activate(agent)
+		}
+	default:
+		for _, agent := range s.agentList {
+			moves += s.activate(agent)
+		}
+	}
+
+	satisfactionRatio := s.tallySatisfaction()
 
 	// Check if the grid is stable
 	if satisfactionRatio >= s.stableLimit {
@@ -119,13 +465,68 @@ func (s *Schelling) Update() error {
 		s.stableCounter = 0
 	}
 
-	// If the grid has been stable for the maxStableCount, increase the threshold
-	if s.stableCounter >= s.maxStableCount {
-		s.threshold += 0.1
-		s.stableCounter = 0 // Reset the stable counter
+	s.stepCount++
+
+	// Let the policy move the thresholds, restarting the stability count
+	// whenever they change
+	shift := s.thresholdPolicy.Next(ThresholdState{
+		Step:         s.stepCount,
+		Shift:        s.thresholdShift,
+		Satisfaction: satisfactionRatio,
+		StableSteps:  s.stableCounter,
+	})
+	if shift != s.thresholdShift {
+		s.thresholdShift = shift
+		s.stableCounter = 0
 	}
 
-	return nil
+	s.metrics = s.computeMetrics()
+	s.recordMetrics()
+	return moves
+}
+
+// activate lets a single agent check its neighborhood and move if unhappy,
+// returning the number of moves made.
+func (s *Schelling) activate(agent *schellingAgent) int {
+	if s.isSatisfied(agent.x, agent.y) || !s.moveAgent(agent.x, agent.y) {
+		return 0
+	}
+	return 1
+}
+
+// tallySatisfaction counts satisfied agents per group in the current world
+// and returns the overall satisfied fraction.
+func (s *Schelling) tallySatisfaction() float64 {
+	for i := range s.groups {
+		s.populations[i] = 0
+		s.satisfied[i] = 0
+	}
+
+	total, satisfied := 0, 0
+	for _, agent := range s.agentList {
+		total++
+		s.populations[agent.group]++
+		if s.isSatisfied(agent.x, agent.y) {
+			satisfied++
+			s.satisfied[agent.group]++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(satisfied) / float64(total)
+}
+
+// SatisfactionByGroup returns the fraction of each group's agents that were
+// satisfied in the last update.
+func (s *Schelling) SatisfactionByGroup() []float64 {
+	ratios := make([]float64, len(s.groups))
+	for i := range s.groups {
+		if s.populations[i] > 0 {
+			ratios[i] = float64(s.satisfied[i]) / float64(s.populations[i])
+		}
+	}
+	return ratios
 }
 
 func (s *Schelling) Draw(screen *ebiten.Image) {
@@ -135,8 +536,15 @@ func (s *Schelling) Draw(screen *ebiten.Image) {
 		}
 	}
 
-	// Draw the current threshold value on the screen
-	ebitenutil.DebugPrint(screen, "Threshold: "+fmt.Sprintf("%.2f", s.threshold))
+	// Draw the threshold, segregation metrics and satisfaction of every group on the screen
+	m := s.metrics
+	text := fmt.Sprintf("Step: %d  Threshold shift: %+.2f  Policy: %s", m.Step, s.thresholdShift, s.thresholdPolicy.Name())
+	text += fmt.Sprintf("\nDissimilarity: %.3f  Like neighbors: %.3f  Interface: %d", m.Dissimilarity, m.LikeNeighborFraction, m.InterfaceLength)
+	text += fmt.Sprintf("\nClusters: %d  Mean size: %.1f  Largest: %d", m.ClusterSizes.Count(), m.ClusterSizes.Mean(), m.ClusterSizes.Max())
+	for i, ratio := range s.SatisfactionByGroup() {
+		text += fmt.Sprintf("\nGroup %d: threshold %.2f  satisfied %.1f%%  isolation %.3f", i+1, s.groups[i].Threshold, ratio*100, m.Isolation[i])
+	}
+	ebitenutil.DebugPrint(screen, text)
 	if s.IsPaused() {
 		ebitenutil.DebugPrintAt(screen, "Paused", screen.Bounds().Dx()/2-30, screen.Bounds().Dy()/2)
 	}
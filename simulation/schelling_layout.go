@@ -0,0 +1,161 @@
+package simulation
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+)
+
+type InitialLayout int
+
+const (
+	// RandomLayout assigns every occupied cell a group at random
+	RandomLayout InitialLayout = iota
+	// ClusteredLayout grows patches of one group around random seed points
+	ClusteredLayout
+	// StripedLayout lays the groups out in vertical stripes, each group's
+	// stripe as wide as its share of the population
+	StripedLayout
+	// CheckerboardLayout alternates the groups in square blocks, ignoring the
+	// proportions
+	CheckerboardLayout
+)
+
+// WithLayout selects how groups are arranged at the start.
+func WithLayout(layout InitialLayout) SchellingOption {
+	return func(s *Schelling) {
+		s.layout = layout
+	}
+}
+
+// WithLayoutScale sets the size in cells of clusters, stripes and
+// checkerboard blocks.
+func WithLayoutScale(scale int) SchellingOption {
+	return func(s *Schelling) {
+		s.layoutScale = scale
+	}
+}
+
+// WithProportions sets the relative share of each group, in the order of
+// the groups already configured.
+func WithProportions(proportions ...float64) SchellingOption {
+	return func(s *Schelling) {
+		for i := range s.groups {
+			if i < len(proportions) {
+				s.groups[i].Proportion = proportions[i]
+			}
+		}
+	}
+}
+
+// WithPopulationImage seeds the grid from an image scaled to the grid size.
+// Pixels matching a group's color become an agent of that group, any other
+// color an empty cell. The empty ratio and layout are ignored.
+func WithPopulationImage(img image.Image) SchellingOption {
+	return func(s *Schelling) {
+		s.population = img
+	}
+}
+
+func LoadPopulationPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// populate fills the grid with agents according to the configured layout
+// or imported population.
+func (s *Schelling) populate() {
+	s.agentList = s.agentList[:0]
+
+	if s.population != nil {
+		for y := range s.agents {
+			for x := range s.agents[y] {
+				if group := s.imageGroup(x, y); group >= 0 && s.habitable(x, y) {
+					s.set(x, y, s.newAgent(group))
+				} else {
+					s.set(x, y, nil)
+				}
+			}
+		}
+		return
+	}
+
+	groupAt := s.layoutGroups()
+	for y := range s.agents {
+		for x := range s.agents[y] {
+			if s.rng.Float64() < s.emptyRatio || !s.habitable(x, y) {
+				s.set(x, y, nil)
+			} else {
+				s.set(x, y, s.newAgent(groupAt(x, y)))
+			}
+		}
+	}
+}
+
+func (s *Schelling) layoutGroups() func(x, y int) int {
+	scale := s.layoutScale
+	if scale < 1 {
+		scale = 1
+	}
+
+	switch s.layout {
+	case ClusteredLayout:
+		count := s.grid.width * s.grid.height / (scale * scale)
+		if count < len(s.groups) {
+			count = len(s.groups)
+		}
+		seeds := make([]cellPos, count)
+		seedGroups := make([]int, count)
+		for i := range seeds {
+			seeds[i] = cellPos{s.rng.Intn(s.grid.width), s.rng.Intn(s.grid.height)}
+			seedGroups[i] = s.randomGroup()
+		}
+		return func(x, y int) int {
+			nearest, nearestDist := 0, -1
+			for i, seed := range seeds {
+				dist := (seed.x-x)*(seed.x-x) + (seed.y-y)*(seed.y-y)
+				if nearestDist < 0 || dist < nearestDist {
+					nearest, nearestDist = i, dist
+				}
+			}
+			return seedGroups[nearest]
+		}
+
+	case StripedLayout:
+		period := scale * len(s.groups)
+		return func(x, y int) int {
+			return s.groupAtFraction((float64(x%period) + 0.5) / float64(period))
+		}
+
+	case CheckerboardLayout:
+		return func(x, y int) int {
+			return (x/scale + y/scale) % len(s.groups)
+		}
+
+	default:
+		return func(x, y int) int {
+			return s.randomGroup()
+		}
+	}
+}
+
+// imageGroup returns the group whose color matches the pixel covering the
+// cell, or -1 for an empty cell.
+func (s *Schelling) imageGroup(x, y int) int {
+	bounds := s.population.Bounds()
+	px := bounds.Min.X + x*bounds.Dx()/s.grid.width
+	py := bounds.Min.Y + y*bounds.Dy()/s.grid.height
+	pixel := color.RGBAModel.Convert(s.population.At(px, py))
+
+	for i, g := range s.groups {
+		if color.RGBAModel.Convert(g.Color) == pixel {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,262 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// tractSize is the side of the square blocks the grid is split into for the
+// dissimilarity, isolation and exposure indices, which compare the makeup of
+// each block with the grid as a whole.
+const tractSize = 8
+
+// maxMetricsHistory bounds how many updates of metrics are kept, the oldest
+// being dropped first.
+const maxMetricsHistory = 10000
+
+type SchellingMetrics struct {
+	Step int
+	// ThresholdShift is the shift the threshold policy applied after this step
+	ThresholdShift float64
+	// Dissimilarity is the multi-group dissimilarity index: 0 when every tract
+	// mirrors the overall population, 1 when groups never share a tract
+	Dissimilarity float64
+	// Isolation[a] is the share of group a in the tract of an average member of a
+	Isolation []float64
+	// Exposure[a][b] is the share of group b in the tract of an average member of a
+	Exposure [][]float64
+	// LikeNeighborFraction averages the fraction of like neighbors over all
+	// agents that have at least one neighbor
+	LikeNeighborFraction float64
+	// ClusterSizes is the distribution of sizes of 4-connected same-group clusters
+	ClusterSizes LogHistogram
+	// InterfaceLength counts 4-adjacent pairs of agents from different groups
+	InterfaceLength int
+	// Satisfaction is the fraction of all agents that are satisfied, as
+	// tallied at the end of the update
+	Satisfaction float64
+}
+
+func (s *Schelling) computeMetrics() SchellingMetrics {
+	groups := len(s.groups)
+	m := SchellingMetrics{
+		Step:           s.stepCount,
+		ThresholdShift: s.thresholdShift,
+		Isolation:      make([]float64, groups),
+		Exposure:       make([][]float64, groups),
+	}
+	for a := range m.Exposure {
+		m.Exposure[a] = make([]float64, groups)
+	}
+
+	tractsX := (s.grid.width + tractSize - 1) / tractSize
+	tractsY := (s.grid.height + tractSize - 1) / tractSize
+	tracts := make([][]int, tractsX*tractsY)
+	for i := range tracts {
+		tracts[i] = make([]int, groups)
+	}
+	totals := make([]int, groups)
+	population := 0
+
+	likeSum := 0.0
+	likeAgents := 0
+	for y := range s.agents {
+		for x, agent := range s.agents[y] {
+			if agent == nil {
+				continue
+			}
+			population++
+			totals[agent.group]++
+			tracts[(y/tractSize)*tractsX+x/tractSize][agent.group]++
+
+			if like, total := s.neighborCounts(agent.group, x, y, x, y); total > 0 {
+				likeSum += float64(like) / float64(total)
+				likeAgents++
+			}
+
+			// Only look right and down so each pair is counted once
+			if x+1 < s.grid.width {
+				if n := s.agents[y][x+1]; n != nil && n.group != agent.group {
+					m.InterfaceLength++
+				}
+			}
+			if y+1 < s.grid.height {
+				if n := s.agents[y+1][x]; n != nil && n.group != agent.group {
+					m.InterfaceLength++
+				}
+			}
+		}
+	}
+	if population == 0 {
+		return m
+	}
+	if likeAgents > 0 {
+		m.LikeNeighborFraction = likeSum / float64(likeAgents)
+	}
+	satisfied := 0
+	for _, n := range s.satisfied {
+		satisfied += n
+	}
+	m.Satisfaction = float64(satisfied) / float64(population)
+
+	// Multi-group dissimilarity, which reduces to the usual two-group index
+	interaction := 0.0
+	for _, total := range totals {
+		p := float64(total) / float64(population)
+		interaction += p * (1 - p)
+	}
+	for _, tract := range tracts {
+		tractPopulation := 0
+		for _, n := range tract {
+			tractPopulation += n
+		}
+		if tractPopulation == 0 {
+			continue
+		}
+
+		for a, n := range tract {
+			share := float64(n) / float64(tractPopulation)
+			if interaction > 0 {
+				overall := float64(totals[a]) / float64(population)
+				m.Dissimilarity += float64(tractPopulation) * math.Abs(share-overall)
+			}
+			if totals[a] == 0 {
+				continue
+			}
+			for b, nb := range tract {
+				m.Exposure[a][b] += float64(n) / float64(totals[a]) * float64(nb) / float64(tractPopulation)
+			}
+		}
+	}
+	if interaction > 0 {
+		m.Dissimilarity /= 2 * float64(population) * interaction
+	}
+	for a := range m.Isolation {
+		m.Isolation[a] = m.Exposure[a][a]
+	}
+
+	s.measureClusters(&m.ClusterSizes)
+	return m
+}
+
+// measureClusters flood-fills 4-connected regions of same-group agents.
+func (s *Schelling) measureClusters(sizes *LogHistogram) {
+	visited := make([][]bool, s.grid.height)
+	for y := range visited {
+		visited[y] = make([]bool, s.grid.width)
+	}
+
+	var stack []cellPos
+	for y := range s.agents {
+		for x, agent := range s.agents[y] {
+			if agent == nil || visited[y][x] {
+				continue
+			}
+
+			size := 0
+			visited[y][x] = true
+			stack = append(stack[:0], cellPos{x, y})
+			for len(stack) > 0 {
+				c := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				size++
+				for _, d := range []cellPos{{0, -1}, {-1, 0}, {1, 0}, {0, 1}} {
+					nx, ny := c.x+d.x, c.y+d.y
+					if nx < 0 || ny < 0 || nx >= s.grid.width || ny >= s.grid.height || visited[ny][nx] {
+						continue
+					}
+					if n := s.agents[ny][nx]; n != nil && n.group == agent.group {
+						visited[ny][nx] = true
+						stack = append(stack, cellPos{nx, ny})
+					}
+				}
+			}
+			sizes.Add(size)
+		}
+	}
+}
+
+func (s *Schelling) Metrics() SchellingMetrics {
+	return s.metrics
+}
+
+func (s *Schelling) recordMetrics() {
+	if len(s.history) < maxMetricsHistory {
+		s.history = append(s.history, s.metrics)
+		return
+	}
+	s.history[s.historyStart] = s.metrics
+	s.historyStart = (s.historyStart + 1) % len(s.history)
+}
+
+// MetricsHistory returns the metrics recorded after each of the last
+// maxMetricsHistory updates, oldest first.
+func (s *Schelling) MetricsHistory() []SchellingMetrics {
+	history := make([]SchellingMetrics, 0, len(s.history))
+	history = append(history, s.history[s.historyStart:]...)
+	return append(history, s.history[:s.historyStart]...)
+}
+
+// WriteMetricsCSV exports the metrics history as a time series, one row per
+// update, with isolation and exposure columns for every group.
+func (s *Schelling) WriteMetricsCSV(w io.Writer) error {
+	header := []string{"step", "threshold_shift", "dissimilarity", "like_neighbor_fraction", "clusters", "mean_cluster_size", "largest_cluster", "interface_length", "satisfaction"}
+	for a := range s.groups {
+		header = append(header, fmt.Sprintf("isolation_%d", a+1))
+	}
+	for a := range s.groups {
+		for b := range s.groups {
+			if a != b {
+				header = append(header, fmt.Sprintf("exposure_%d_%d", a+1, b+1))
+			}
+		}
+	}
+	out := csv.NewWriter(w)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	for _, m := range s.MetricsHistory() {
+		row := []string{
+			fmt.Sprint(m.Step),
+			fmt.Sprintf("%g", m.ThresholdShift),
+			fmt.Sprintf("%g", m.Dissimilarity),
+			fmt.Sprintf("%g", m.LikeNeighborFraction),
+			fmt.Sprint(m.ClusterSizes.Count()),
+			fmt.Sprintf("%g", m.ClusterSizes.Mean()),
+			fmt.Sprint(m.ClusterSizes.Max()),
+			fmt.Sprint(m.InterfaceLength),
+			fmt.Sprintf("%g", m.Satisfaction),
+		}
+		for _, v := range m.Isolation {
+			row = append(row, fmt.Sprintf("%g", v))
+		}
+		for a := range m.Exposure {
+			for b, v := range m.Exposure[a] {
+				if a != b {
+					row = append(row, fmt.Sprintf("%g", v))
+				}
+			}
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func (s *Schelling) ExportMetrics(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.WriteMetricsCSV(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,215 @@
+package simulation
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"image/color"
+	"io"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// SchellingSweep runs Schelling headless over every combination of its
+// parameter lists, Replicates times each, spreading the runs across Workers
+// goroutines. Runs use NoEscalation unless Options pick another threshold
+// policy, so by default each run keeps the threshold it was started with.
+type SchellingSweep struct {
+	Width       int
+	Height      int
+	Thresholds  []float64
+	EmptyRatios []float64
+	Proportions [][]float64 // One entry per population mix; its length is the number of groups
+	Radii       []int
+	Replicates  int
+	MaxSteps    int   // Runs that neither converge nor revisit a state stop here
+	Seed        int64 // Run i is seeded with Seed+i, so a sweep can be repeated exactly
+	Workers     int   // Defaults to the number of CPUs
+	// Options are applied to every run before the swept parameters, e.g. to
+	// pick a relocation strategy or schedule
+	Options []SchellingOption
+}
+
+// SweepOutcome is how a run ended. A run that revisits an earlier state has
+// not necessarily entered a cycle: relocation and schedules draw random
+// numbers, so the same layout and thresholds can lead somewhere new the
+// second time. Such runs are stopped anyway as they are unlikely to settle.
+type SweepOutcome string
+
+const (
+	SweepConverged SweepOutcome = "converged" // A full update in which no agent moved
+	SweepRevisited SweepOutcome = "revisited" // The grid and thresholds returned to an earlier state
+	SweepMaxSteps  SweepOutcome = "max_steps"
+)
+
+type sweepRun struct {
+	id          int
+	threshold   float64
+	emptyRatio  float64
+	proportions []float64
+	radius      int
+	replicate   int
+	seed        int64
+}
+
+type sweepResult struct {
+	run     sweepRun
+	policy  string
+	outcome SweepOutcome
+	steps   int
+	metrics SchellingMetrics
+}
+
+func (sw SchellingSweep) runs() []sweepRun {
+	var runs []sweepRun
+	for _, threshold := range sw.Thresholds {
+		for _, emptyRatio := range sw.EmptyRatios {
+			for _, proportions := range sw.Proportions {
+				for _, radius := range sw.Radii {
+					for rep := 0; rep < sw.Replicates; rep++ {
+						id := len(runs)
+						runs = append(runs, sweepRun{
+							id:          id,
+							threshold:   threshold,
+							emptyRatio:  emptyRatio,
+							proportions: proportions,
+							radius:      radius,
+							replicate:   rep,
+							seed:        sw.Seed + int64(id),
+						})
+					}
+				}
+			}
+		}
+	}
+	return runs
+}
+
+// Run executes the sweep and writes one CSV row per run to w as runs finish.
+func (sw SchellingSweep) Run(w io.Writer) error {
+	workers := sw.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	runs := make(chan sweepRun)
+	results := make(chan sweepResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for run := range runs {
+				results <- sw.execute(run)
+			}
+		}()
+	}
+	go func() {
+		for _, run := range sw.runs() {
+			runs <- run
+		}
+		close(runs)
+		wg.Wait()
+		close(results)
+	}()
+
+	out := csv.NewWriter(w)
+	err := out.Write(strings.Split("run,threshold,empty_ratio,proportions,radius,replicate,seed,threshold_policy,final_threshold_shift,outcome,steps,dissimilarity,like_neighbor_fraction,clusters,largest_cluster,interface_length,satisfaction", ","))
+	for r := range results {
+		if err != nil {
+			continue // Drain the remaining results so the workers can exit
+		}
+		proportions := make([]string, len(r.run.proportions))
+		for i, p := range r.run.proportions {
+			proportions[i] = fmt.Sprintf("%g", p)
+		}
+		m := r.metrics
+		err = out.Write([]string{
+			fmt.Sprint(r.run.id),
+			fmt.Sprintf("%g", r.run.threshold),
+			fmt.Sprintf("%g", r.run.emptyRatio),
+			strings.Join(proportions, ";"),
+			fmt.Sprint(r.run.radius),
+			fmt.Sprint(r.run.replicate),
+			fmt.Sprint(r.run.seed),
+			r.policy,
+			fmt.Sprintf("%g", m.ThresholdShift),
+			string(r.outcome),
+			fmt.Sprint(r.steps),
+			fmt.Sprintf("%g", m.Dissimilarity),
+			fmt.Sprintf("%g", m.LikeNeighborFraction),
+			fmt.Sprint(m.ClusterSizes.Count()),
+			fmt.Sprint(m.ClusterSizes.Max()),
+			fmt.Sprint(m.InterfaceLength),
+			fmt.Sprintf("%g", m.Satisfaction),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func (sw SchellingSweep) execute(run sweepRun) sweepResult {
+	groups := make([]SchellingGroup, len(run.proportions))
+	for i, p := range run.proportions {
+		shade := uint8(255 * (i + 1) / len(run.proportions))
+		groups[i] = SchellingGroup{Color: color.Gray{Y: shade}, Proportion: p, Threshold: run.threshold}
+	}
+
+	opts := []SchellingOption{WithThresholdPolicy(NoEscalation{})}
+	opts = append(opts, sw.Options...)
+	opts = append(opts,
+		WithGroups(groups...),
+		WithEmptyRatio(run.emptyRatio),
+		WithNeighborhoodRadius(run.radius),
+		WithSeed(run.seed),
+	)
+	s := NewSchelling(sw.Width, sw.Height, run.threshold, nil, opts...)
+
+	seen := map[uint64]bool{s.stateHash(): true}
+	result := sweepResult{run: run, policy: s.thresholdPolicy.Name(), outcome: SweepMaxSteps}
+	for result.steps < sw.MaxSteps {
+		moves := s.advance()
+		result.steps++
+		if moves == 0 {
+			result.outcome = SweepConverged
+			break
+		}
+		h := s.stateHash()
+		if seen[h] {
+			result.outcome = SweepRevisited
+			break
+		}
+		seen[h] = true
+	}
+	result.metrics = s.Metrics()
+	return result
+}
+
+// stateHash fingerprints which group occupies every cell along with what the
+// threshold policy acts on, to detect runs that revisit an earlier state.
+func (s *Schelling) stateHash() uint64 {
+	h := fnv.New64a()
+	var policy []byte
+	policy = binary.LittleEndian.AppendUint64(policy, math.Float64bits(s.thresholdShift))
+	policy = binary.LittleEndian.AppendUint64(policy, uint64(s.stableCounter))
+	h.Write(policy)
+	buf := make([]byte, 0, s.grid.width)
+	for y := range s.agents {
+		buf = buf[:0]
+		for _, agent := range s.agents[y] {
+			if agent == nil {
+				buf = append(buf, 0)
+			} else {
+				buf = append(buf, byte(agent.group+1))
+			}
+		}
+		h.Write(buf)
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,134 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+// ThresholdState is what a ThresholdPolicy sees after each update.
+type ThresholdState struct {
+	Step         int     // Updates completed so far
+	Shift        float64 // Current shift added to every agent's threshold
+	Satisfaction float64 // Fraction of agents satisfied after the update
+	StableSteps  int     // Consecutive updates with satisfaction at or above the stable limit
+}
+
+// ThresholdPolicy decides how the shift added to every agent's own threshold
+// evolves over a run. Policies must not keep state of their own, since one
+// policy value may be shared by several runs of a sweep.
+type ThresholdPolicy interface {
+	Name() string
+	Next(state ThresholdState) float64
+}
+
+// NoEscalation keeps every agent at the threshold it started with.
+type NoEscalation struct{}
+
+func (NoEscalation) Name() string {
+	return "none"
+}
+
+func (NoEscalation) Next(state ThresholdState) float64 {
+	return state.Shift
+}
+
+// LinearEscalation raises the shift by Rate every update, up to Max.
+type LinearEscalation struct {
+	Rate float64
+	Max  float64
+}
+
+func (p LinearEscalation) Name() string {
+	return fmt.Sprintf("linear(rate=%g,max=%g)", p.Rate, p.Max)
+}
+
+func (p LinearEscalation) Next(state ThresholdState) float64 {
+	return math.Min(p.Rate*float64(state.Step), p.Max)
+}
+
+// StepEscalation raises the shift by Increment whenever the grid has been
+// stable for StableSteps updates in a row, up to Max.
+type StepEscalation struct {
+	Increment   float64
+	StableSteps int
+	Max         float64
+}
+
+func (p StepEscalation) Name() string {
+	return fmt.Sprintf("step(increment=%g,after=%d,max=%g)", p.Increment, p.StableSteps, p.Max)
+}
+
+func (p StepEscalation) Next(state ThresholdState) float64 {
+	if state.StableSteps < p.StableSteps {
+		return state.Shift
+	}
+	return math.Min(state.Shift+p.Increment, p.Max)
+}
+
+// AnnealedEscalation moves the shift smoothly towards Target, covering about
+// 63% of the distance every TimeScale updates.
+type AnnealedEscalation struct {
+	Target    float64
+	TimeScale float64
+}
+
+func (p AnnealedEscalation) Name() string {
+	return fmt.Sprintf("annealed(target=%g,timescale=%g)", p.Target, p.TimeScale)
+}
+
+func (p AnnealedEscalation) Next(state ThresholdState) float64 {
+	return p.Target * (1 - math.Exp(-float64(state.Step)/p.TimeScale))
+}
+
+type ThresholdPoint struct {
+	Step  int
+	Shift float64
+}
+
+// ScheduledThreshold follows a user-supplied schedule, interpolating linearly
+// between points sorted by step and holding the last value afterwards.
+type ScheduledThreshold []ThresholdPoint
+
+func (p ScheduledThreshold) Name() string {
+	return fmt.Sprintf("scheduled(%d points)", len(p))
+}
+
+func (p ScheduledThreshold) Next(state ThresholdState) float64 {
+	if len(p) == 0 {
+		return state.Shift
+	}
+	if state.Step <= p[0].Step {
+		return p[0].Shift
+	}
+	for i := 1; i < len(p); i++ {
+		if state.Step <= p[i].Step {
+			a, b := p[i-1], p[i]
+			t := float64(state.Step-a.Step) / float64(b.Step-a.Step)
+			return a.Shift + (b.Shift-a.Shift)*t
+		}
+	}
+	return p[len(p)-1].Shift
+}
+
+// ThresholdFunc adapts an arbitrary function into a policy, for schedules
+// that depend on more than the step.
+type ThresholdFunc func(state ThresholdState) float64
+
+func (f ThresholdFunc) Name() string {
+	return "custom"
+}
+
+func (f ThresholdFunc) Next(state ThresholdState) float64 {
+	return f(state)
+}
+
+// DefaultThresholdPolicy is the historical behavior: raise every threshold by
+// 0.1 after five stable updates, now capped so thresholds stop at 1.
+var DefaultThresholdPolicy ThresholdPolicy = StepEscalation{Increment: 0.1, StableSteps: 5, Max: 1}
+
+// WithThresholdPolicy selects how thresholds change over the run.
+func WithThresholdPolicy(policy ThresholdPolicy) SchellingOption {
+	return func(s *Schelling) {
+		s.thresholdPolicy = policy
+	}
+}
@@ -0,0 +1,171 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+type SmoothLifeStep int
+
+const (
+	// DiscreteStep replaces every cell with the transition function directly
+	DiscreteStep SmoothLifeStep = iota
+	// SmoothStep integrates f' = 2s(n, m) - 1
+	SmoothStep
+	// RelaxStep integrates f' = s(n, m) - f
+	RelaxStep
+)
+
+type SmoothLifeParams struct {
+	OuterRadius float64 // Radius of the neighborhood annulus; the inner disk is a third of it
+	B1, B2      float64 // Birth interval
+	D1, D2      float64 // Death interval
+	AlphaN      float64 // Sigmoid width for the outer filling
+	AlphaM      float64 // Sigmoid width for the inner filling
+	Dt          float64 // Time step for the smooth variants
+	Step        SmoothLifeStep
+}
+
+var DefaultSmoothLifeParams = SmoothLifeParams{
+	OuterRadius: 10,
+	B1:          0.278,
+	B2:          0.365,
+	D1:          0.267,
+	D2:          0.445,
+	AlphaN:      0.028,
+	AlphaM:      0.147,
+	Dt:          0.1,
+	Step:        DiscreteStep,
+}
+
+type SmoothLife struct {
+	BaseSimulation
+	width    int
+	height   int
+	params   SmoothLifeParams
+	field    []float64
+	inner    []float64
+	outer    []float64
+	conv     *fftConvolver
+	disk     []complex128
+	annulus  []complex128
+	colormap Colormap
+	renderer *fieldRenderer
+}
+
+func NewSmoothLife(width, height int, params SmoothLifeParams, cmap Colormap) *SmoothLife {
+	sl := &SmoothLife{
+		width:    width,
+		height:   height,
+		params:   params,
+		field:    make([]float64, width*height),
+		inner:    make([]float64, width*height),
+		outer:    make([]float64, width*height),
+		conv:     newFFTConvolver(width, height),
+		colormap: cmap,
+		renderer: newFieldRenderer(width, height),
+	}
+
+	// Both kernels are anti-aliased over one cell at their edges
+	ra := params.OuterRadius
+	ri := ra / 3
+	sl.disk = sl.conv.kernelSpectrum(radialKernel(width, height, ri+1, func(d float64) float64 {
+		return clamp01(ri + 0.5 - d)
+	}))
+	sl.annulus = sl.conv.kernelSpectrum(radialKernel(width, height, ra+1, func(d float64) float64 {
+		return clamp01(ra+0.5-d) * (1 - clamp01(ri+0.5-d))
+	}))
+
+	sl.seed()
+	return sl
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// seed scatters filled squares about the size of the inner disk.
+func (sl *SmoothLife) seed() {
+	for i := range sl.field {
+		sl.field[i] = 0
+	}
+
+	size := int(math.Max(1, sl.params.OuterRadius/3*2))
+	count := sl.width * sl.height / (size * size * 4)
+	for p := 0; p < count; p++ {
+		cx, cy := rand.Intn(sl.width), rand.Intn(sl.height)
+		for dy := 0; dy < size; dy++ {
+			for dx := 0; dx < size; dx++ {
+				x := (cx + dx) % sl.width
+				y := (cy + dy) % sl.height
+				sl.field[y*sl.width+x] = 1
+			}
+		}
+	}
+}
+
+func (sl *SmoothLife) sigma1(x, a, alpha float64) float64 {
+	return 1 / (1 + math.Exp(-(x-a)*4/alpha))
+}
+
+func (sl *SmoothLife) sigma2(x, a, b float64) float64 {
+	return sl.sigma1(x, a, sl.params.AlphaN) * (1 - sl.sigma1(x, b, sl.params.AlphaN))
+}
+
+func (sl *SmoothLife) sigmaM(x, y, m float64) float64 {
+	w := sl.sigma1(m, 0.5, sl.params.AlphaM)
+	return x*(1-w) + y*w
+}
+
+// transition is the SmoothLife analogue of the B3/S23 rule: n is the filling
+// of the annulus, m the filling of the inner disk.
+func (sl *SmoothLife) transition(n, m float64) float64 {
+	p := sl.params
+	return sl.sigma2(n, sl.sigmaM(p.B1, p.D1, m), sl.sigmaM(p.B2, p.D2, m))
+}
+
+func (sl *SmoothLife) Update() error {
+	sl.UpdatePauseState()
+	if sl.IsPaused() {
+		return nil
+	}
+
+	// Right-click to reseed the field
+	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight) {
+		sl.seed()
+	}
+
+	sl.conv.load(sl.field)
+	sl.conv.apply(sl.disk, sl.inner)
+	sl.conv.apply(sl.annulus, sl.outer)
+
+	for i := range sl.field {
+		s := sl.transition(sl.outer[i], sl.inner[i])
+		switch sl.params.Step {
+		case SmoothStep:
+			sl.field[i] = clamp01(sl.field[i] + sl.params.Dt*(2*s-1))
+		case RelaxStep:
+			sl.field[i] = clamp01(sl.field[i] + sl.params.Dt*(s-sl.field[i]))
+		default:
+			sl.field[i] = s
+		}
+	}
+	return nil
+}
+
+func (sl *SmoothLife) Draw(screen *ebiten.Image) {
+	sl.renderer.draw(screen, sl.field, sl.colormap)
+
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("ra: %.0f  birth: %.3f-%.3f  death: %.3f-%.3f", sl.params.OuterRadius, sl.params.B1, sl.params.B2, sl.params.D1, sl.params.D2))
+	if sl.IsPaused() {
+		ebitenutil.DebugPrintAt(screen, "Paused", screen.Bounds().Dx()/2-30, screen.Bounds().Dy()/2)
+	}
+}
+
+func (sl *SmoothLife) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return sl.width * cellSize, sl.height * cellSize
+}
@@ -0,0 +1,71 @@
+package simulation
+
+import (
+	"fmt"
+	"io"
+)
+
+// LogHistogram bins positive event sizes into powers of two, so heavy-tailed
+// distributions such as fire or avalanche sizes come out as straight lines
+// on log-log axes.
+type LogHistogram struct {
+	counts []int
+	total  int
+	max    int
+	sum    int
+}
+
+func (h *LogHistogram) Add(size int) {
+	if size <= 0 {
+		return
+	}
+
+	bin := 0
+	for 1<<(bin+1) <= size {
+		bin++
+	}
+	for len(h.counts) <= bin {
+		h.counts = append(h.counts, 0)
+	}
+	h.counts[bin]++
+	h.total++
+	h.sum += size
+	if size > h.max {
+		h.max = size
+	}
+}
+
+func (h *LogHistogram) Count() int {
+	return h.total
+}
+
+func (h *LogHistogram) Max() int {
+	return h.max
+}
+
+func (h *LogHistogram) Mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return float64(h.sum) / float64(h.total)
+}
+
+// WriteCSV writes one row per bin. The density column divides each count by
+// the bin width and the number of events, which is the value to plot against
+// the bin start on log-log axes.
+func (h *LogHistogram) WriteCSV(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "bin_start,bin_end,count,density"); err != nil {
+		return err
+	}
+	for bin, count := range h.counts {
+		start, end := 1<<bin, 1<<(bin+1)
+		density := 0.0
+		if h.total > 0 {
+			density = float64(count) / float64(end-start) / float64(h.total)
+		}
+		if _, err := fmt.Fprintf(w, "%d,%d,%d,%g\n", start, end, count, density); err != nil {
+			return err
+		}
+	}
+	return nil
+}
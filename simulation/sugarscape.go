@@ -0,0 +1,656 @@
+package simulation
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+type SugarscapeParams struct {
+	Name          string
+	Agents        int
+	MaxVision     int
+	MaxMetabolism int
+	MinEndowment  float64 // Range of sugar (and spice) agents are born with
+	MaxEndowment  float64
+	MinLifespan   int
+	MaxLifespan   int
+	MaxCapacity   int     // Most sugar a single site can hold
+	Growback      float64 // Sugar regrown per site per update
+	CombatLimit   float64 // Most wealth a winner can loot from a victim
+
+	Spice        bool // Add a second commodity with its own landscape and metabolism
+	Reproduction bool
+	Inheritance  bool // Dead agents' wealth is split among their living children
+	Combat       bool // Agents may attack weaker agents of the other tribe when moving
+	Trade        bool // Neighbors trade sugar for spice; needs Spice
+	Replacement  bool // Each agent that dies is replaced by a new random agent
+
+	// FromTerrain derives the landscapes from Terrain noise seeded with
+	// TerrainSeed instead of the classic two-peaked landscape
+	FromTerrain bool
+	TerrainSeed int64
+}
+
+var DefaultSugarscapeParams = SugarscapeParams{
+	Name:          "Classic",
+	Agents:        1000,
+	MaxVision:     6,
+	MaxMetabolism: 4,
+	MinEndowment:  5,
+	MaxEndowment:  25,
+	MinLifespan:   60,
+	MaxLifespan:   100,
+	MaxCapacity:   4,
+	Growback:      1,
+	CombatLimit:   6,
+	Replacement:   true,
+}
+
+// GetSugarscapePresets returns the rule sets cycled through with the P key:
+// the classic model, spice and trade, tribal combat, evolution by
+// reproduction and inheritance, and trade on landscapes from terrain noise.
+func GetSugarscapePresets() []SugarscapeParams {
+	trade := DefaultSugarscapeParams
+	trade.Name = "Spice and trade"
+	trade.Spice, trade.Trade = true, true
+
+	combat := DefaultSugarscapeParams
+	combat.Name = "Combat"
+	combat.Combat = true
+
+	evolution := DefaultSugarscapeParams
+	evolution.Name = "Evolution"
+	evolution.Reproduction, evolution.Inheritance, evolution.Replacement = true, true, false
+
+	terrain := trade
+	terrain.Name = "Terrain"
+	terrain.FromTerrain = true
+
+	return []SugarscapeParams{DefaultSugarscapeParams, trade, combat, evolution, terrain}
+}
+
+var tribeColors = []color.Color{
+	color.RGBA{220, 20, 60, 255},  // Crimson
+	color.RGBA{30, 144, 255, 255}, // Blue
+}
+
+type sugarAgent struct {
+	x, y            int
+	vision          int
+	sugarMetabolism float64
+	spiceMetabolism float64
+	sugar           float64
+	spice           float64
+	endowment       float64 // Sugar the agent was born with, the wealth it needs to reproduce
+	spiceEndowment  float64 // Spice the agent was born with, also needed to reproduce when spice is on
+	age             int
+	lifespan        int
+	female          bool
+	tribe           int
+	children        []*sugarAgent
+	dead            bool
+}
+
+type Sugarscape struct {
+	BaseSimulation
+	grid          *Grid
+	params        SugarscapeParams
+	preset        int // Index into GetSugarscapePresets, -1 for custom params
+	sugar         [][]float64
+	sugarCapacity [][]float64
+	spice         [][]float64
+	spiceCapacity [][]float64
+	occupant      [][]*sugarAgent
+	agents        []*sugarAgent
+	step          int
+	gini          float64
+}
+
+func NewSugarscape(width, height int, params SugarscapeParams) *Sugarscape {
+	ss := &Sugarscape{
+		grid:          NewGrid(width, height),
+		params:        params,
+		sugar:         make([][]float64, height),
+		sugarCapacity: make([][]float64, height),
+		spice:         make([][]float64, height),
+		spiceCapacity: make([][]float64, height),
+		occupant:      make([][]*sugarAgent, height),
+		preset:        -1,
+	}
+	for i, preset := range GetSugarscapePresets() {
+		if preset == params {
+			ss.preset = i
+		}
+	}
+	for y := 0; y < height; y++ {
+		ss.sugar[y] = make([]float64, width)
+		ss.sugarCapacity[y] = make([]float64, width)
+		ss.spice[y] = make([]float64, width)
+		ss.spiceCapacity[y] = make([]float64, width)
+		ss.occupant[y] = make([]*sugarAgent, width)
+	}
+
+	ss.buildLandscape()
+	for i := 0; i < params.Agents && i < width*height; i++ {
+		x, y := ss.randomEmptyCell()
+		ss.place(ss.newAgent(), x, y)
+	}
+	ss.gini = ss.Gini()
+	return ss
+}
+
+// buildLandscape sets the capacities, either as the classic landscape with
+// sugar peaks in the north-east and south-west (and spice on the other
+// diagonal) or from terrain noise, and fills every site to capacity.
+func (ss *Sugarscape) buildLandscape() {
+	w, h := ss.grid.width, ss.grid.height
+	maxCap := float64(ss.params.MaxCapacity)
+
+	var sugarTerrain, spiceTerrain *Terrain
+	if ss.params.FromTerrain {
+		sugarTerrain = NewTerrain(w, h, ss.params.TerrainSeed, GetBiomes())
+		spiceTerrain = NewTerrain(w, h, ss.params.TerrainSeed+1, GetBiomes())
+	}
+
+	band := float64(min(w, h)) / 8
+	peak := func(x, y int, peaks [2]cellPos) float64 {
+		capacity := 0.0
+		for _, p := range peaks {
+			dist := math.Hypot(float64(x-p.x), float64(y-p.y))
+			capacity = math.Max(capacity, maxCap-math.Floor(dist/band))
+		}
+		return capacity
+	}
+	sugarPeaks := [2]cellPos{{w * 3 / 4, h / 4}, {w / 4, h * 3 / 4}}
+	spicePeaks := [2]cellPos{{w / 4, h / 4}, {w * 3 / 4, h * 3 / 4}}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if sugarTerrain != nil {
+				ss.sugarCapacity[y][x] = math.Floor(sugarTerrain.heightAt(x, y) * (maxCap + 1))
+				ss.spiceCapacity[y][x] = math.Floor(spiceTerrain.heightAt(x, y) * (maxCap + 1))
+			} else {
+				ss.sugarCapacity[y][x] = peak(x, y, sugarPeaks)
+				ss.spiceCapacity[y][x] = peak(x, y, spicePeaks)
+			}
+			ss.sugarCapacity[y][x] = math.Max(0, math.Min(maxCap, ss.sugarCapacity[y][x]))
+			ss.spiceCapacity[y][x] = math.Max(0, math.Min(maxCap, ss.spiceCapacity[y][x]))
+			if !ss.params.Spice {
+				ss.spiceCapacity[y][x] = 0
+			}
+			ss.sugar[y][x] = ss.sugarCapacity[y][x]
+			ss.spice[y][x] = ss.spiceCapacity[y][x]
+		}
+	}
+}
+
+func (ss *Sugarscape) randomEmptyCell() (int, int) {
+	for {
+		x, y := rand.Intn(ss.grid.width), rand.Intn(ss.grid.height)
+		if ss.occupant[y][x] == nil {
+			return x, y
+		}
+	}
+}
+
+func (ss *Sugarscape) newAgent() *sugarAgent {
+	p := ss.params
+	endowment := p.MinEndowment + rand.Float64()*(p.MaxEndowment-p.MinEndowment)
+	a := &sugarAgent{
+		vision:          1 + rand.Intn(p.MaxVision),
+		sugarMetabolism: float64(1 + rand.Intn(p.MaxMetabolism)),
+		sugar:           endowment,
+		endowment:       endowment,
+		lifespan:        p.MinLifespan + rand.Intn(p.MaxLifespan-p.MinLifespan+1),
+		female:          rand.Intn(2) == 0,
+		tribe:           rand.Intn(len(tribeColors)),
+	}
+	if p.Spice {
+		a.spiceMetabolism = float64(1 + rand.Intn(p.MaxMetabolism))
+		a.spice = p.MinEndowment + rand.Float64()*(p.MaxEndowment-p.MinEndowment)
+		a.spiceEndowment = a.spice
+	}
+	return a
+}
+
+func (ss *Sugarscape) place(a *sugarAgent, x, y int) {
+	a.x, a.y = x, y
+	ss.occupant[y][x] = a
+	ss.agents = append(ss.agents, a)
+}
+
+func (ss *Sugarscape) moveTo(a *sugarAgent, x, y int) {
+	ss.occupant[a.y][a.x] = nil
+	a.x, a.y = x, y
+	ss.occupant[y][x] = a
+}
+
+// welfare is the Cobb-Douglas welfare of holding the given amounts; with
+// sugar only it is simply the sugar.
+func (ss *Sugarscape) welfare(a *sugarAgent, sugar, spice float64) float64 {
+	if !ss.params.Spice {
+		return sugar
+	}
+	total := a.sugarMetabolism + a.spiceMetabolism
+	return math.Pow(math.Max(sugar, 0), a.sugarMetabolism/total) * math.Pow(math.Max(spice, 0), a.spiceMetabolism/total)
+}
+
+// move looks along the four lattice directions as far as the agent's vision
+// and goes to the unoccupied site (or, with combat, a site held by a weaker
+// agent of the other tribe) with the best welfare, preferring nearer sites.
+func (ss *Sugarscape) move(a *sugarAgent) {
+	bestX, bestY := a.x, a.y
+	bestWelfare := ss.welfare(a, a.sugar+ss.sugar[a.y][a.x], a.spice+ss.spice[a.y][a.x])
+	bestDist := 0
+
+	directions := []cellPos{{0, -1}, {-1, 0}, {1, 0}, {0, 1}}
+	rand.Shuffle(len(directions), func(i, j int) { directions[i], directions[j] = directions[j], directions[i] })
+	for _, d := range directions {
+		for dist := 1; dist <= a.vision; dist++ {
+			x := (a.x + d.x*dist + ss.grid.width) % ss.grid.width
+			y := (a.y + d.y*dist + ss.grid.height) % ss.grid.height
+
+			loot := 0.0
+			if other := ss.occupant[y][x]; other != nil {
+				if !ss.params.Combat || other.tribe == a.tribe || other.sugar >= a.sugar {
+					continue
+				}
+				loot = math.Min(other.sugar, ss.params.CombatLimit)
+			}
+
+			w := ss.welfare(a, a.sugar+ss.sugar[y][x]+loot, a.spice+ss.spice[y][x])
+			if w > bestWelfare || (w == bestWelfare && dist < bestDist) {
+				bestX, bestY, bestWelfare, bestDist = x, y, w, dist
+			}
+		}
+	}
+
+	if victim := ss.occupant[bestY][bestX]; victim != nil && victim != a {
+		loot := math.Min(victim.sugar, ss.params.CombatLimit)
+		a.sugar += loot
+		victim.sugar -= loot
+		ss.kill(victim)
+	}
+	ss.moveTo(a, bestX, bestY)
+}
+
+func (ss *Sugarscape) kill(a *sugarAgent) {
+	if a.dead {
+		return
+	}
+	a.dead = true
+	ss.occupant[a.y][a.x] = nil
+
+	if ss.params.Inheritance {
+		var heirs []*sugarAgent
+		for _, c := range a.children {
+			if !c.dead {
+				heirs = append(heirs, c)
+			}
+		}
+		for _, c := range heirs {
+			c.sugar += math.Max(a.sugar, 0) / float64(len(heirs))
+			c.spice += math.Max(a.spice, 0) / float64(len(heirs))
+		}
+	}
+	a.children = nil
+}
+
+// dropDeadChildren forgets children that have died, so long-lived parents
+// don't keep them around.
+func dropDeadChildren(a *sugarAgent) {
+	living := a.children[:0]
+	for _, c := range a.children {
+		if !c.dead {
+			living = append(living, c)
+		}
+	}
+	clear(a.children[len(living):])
+	a.children = living
+}
+
+func (ss *Sugarscape) isFertile(a *sugarAgent) bool {
+	if a.dead || a.age < 12 || a.sugar < a.endowment {
+		return false
+	}
+	if ss.params.Spice && a.spice < a.spiceEndowment {
+		return false
+	}
+	if a.female {
+		return a.age <= 50
+	}
+	return a.age <= 60
+}
+
+// reproduce pairs a fertile agent with each fertile neighbor of the opposite
+// sex, placing a child on an empty site next to either parent. Each parent
+// hands over half of its own sugar endowment, and half of its spice
+// endowment when spice is on.
+func (ss *Sugarscape) reproduce(a *sugarAgent) {
+	for _, d := range []cellPos{{0, -1}, {-1, 0}, {1, 0}, {0, 1}} {
+		if !ss.isFertile(a) {
+			return
+		}
+		mate := ss.occupant[(a.y+d.y+ss.grid.height)%ss.grid.height][(a.x+d.x+ss.grid.width)%ss.grid.width]
+		if mate == nil || mate.female == a.female || !ss.isFertile(mate) {
+			continue
+		}
+		x, y, ok := ss.emptyNeighbor(a)
+		if !ok {
+			x, y, ok = ss.emptyNeighbor(mate)
+		}
+		if !ok {
+			continue
+		}
+
+		child := ss.newAgent()
+		parents := []*sugarAgent{a, mate}
+		child.vision = parents[rand.Intn(2)].vision
+		child.sugarMetabolism = parents[rand.Intn(2)].sugarMetabolism
+		child.spiceMetabolism = parents[rand.Intn(2)].spiceMetabolism
+		child.tribe = parents[rand.Intn(2)].tribe
+		child.sugar, child.spice = 0, 0
+		for _, p := range parents {
+			child.sugar += p.endowment / 2
+			p.sugar -= p.endowment / 2
+			if ss.params.Spice {
+				child.spice += p.spiceEndowment / 2
+				p.spice -= p.spiceEndowment / 2
+			}
+			p.children = append(p.children, child)
+		}
+		child.endowment = child.sugar
+		child.spiceEndowment = child.spice
+		ss.place(child, x, y)
+	}
+}
+
+func (ss *Sugarscape) emptyNeighbor(a *sugarAgent) (int, int, bool) {
+	for _, d := range []cellPos{{0, -1}, {-1, 0}, {1, 0}, {0, 1}} {
+		x := (a.x + d.x + ss.grid.width) % ss.grid.width
+		y := (a.y + d.y + ss.grid.height) % ss.grid.height
+		if ss.occupant[y][x] == nil {
+			return x, y, true
+		}
+	}
+	return 0, 0, false
+}
+
+// mrs is the marginal rate of substitution: how much spice the agent would
+// give up for one unit of sugar.
+func (ss *Sugarscape) mrs(a *sugarAgent) float64 {
+	return (a.spice / a.spiceMetabolism) / (a.sugar / a.sugarMetabolism)
+}
+
+// trade bargains with every neighbor at the geometric mean of the two MRSs,
+// one unit at a time, as long as both sides gain welfare and their MRSs
+// don't cross.
+func (ss *Sugarscape) trade(a *sugarAgent) {
+	for _, d := range []cellPos{{0, -1}, {-1, 0}, {1, 0}, {0, 1}} {
+		b := ss.occupant[(a.y+d.y+ss.grid.height)%ss.grid.height][(a.x+d.x+ss.grid.width)%ss.grid.width]
+		if b == nil || b.dead {
+			continue
+		}
+
+		for i := 0; i < 100; i++ {
+			mrsA, mrsB := ss.mrs(a), ss.mrs(b)
+			if math.IsNaN(mrsA) || math.IsNaN(mrsB) || mrsA == mrsB {
+				break
+			}
+
+			// The agent with the higher MRS buys sugar with spice
+			buyer, seller := a, b
+			if mrsA < mrsB {
+				buyer, seller = b, a
+			}
+			price := math.Sqrt(mrsA * mrsB)
+			sugarAmount, spiceAmount := 1.0, price
+			if price < 1 {
+				sugarAmount, spiceAmount = 1/price, 1
+			}
+
+			before := ss.mrs(buyer) > ss.mrs(seller)
+			if ss.welfare(buyer, buyer.sugar+sugarAmount, buyer.spice-spiceAmount) <= ss.welfare(buyer, buyer.sugar, buyer.spice) ||
+				ss.welfare(seller, seller.sugar-sugarAmount, seller.spice+spiceAmount) <= ss.welfare(seller, seller.sugar, seller.spice) {
+				break
+			}
+			buyer.sugar += sugarAmount
+			buyer.spice -= spiceAmount
+			seller.sugar -= sugarAmount
+			seller.spice += spiceAmount
+			if (ss.mrs(buyer) > ss.mrs(seller)) != before {
+				// Undo the trade that made the MRSs cross
+				buyer.sugar -= sugarAmount
+				buyer.spice += spiceAmount
+				seller.sugar += sugarAmount
+				seller.spice -= spiceAmount
+				break
+			}
+		}
+	}
+}
+
+// restart replaces the model with a fresh one using params, keeping the
+// pause state.
+func (ss *Sugarscape) restart(params SugarscapeParams) {
+	base := ss.BaseSimulation
+	*ss = *NewSugarscape(ss.grid.width, ss.grid.height, params)
+	ss.BaseSimulation = base
+}
+
+func (ss *Sugarscape) Update() error {
+	ss.UpdatePauseState()
+
+	// Press E to export the current Lorenz curve to the working directory
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		if err := ss.ExportLorenz("sugarscape_lorenz.csv"); err != nil {
+			log.Printf("exporting Lorenz curve: %v", err)
+		}
+	}
+
+	// Press P to restart with the next preset
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		presets := GetSugarscapePresets()
+		ss.restart(presets[(ss.preset+1)%len(presets)])
+	}
+
+	if ss.IsPaused() {
+		return nil
+	}
+
+	order := make([]*sugarAgent, len(ss.agents))
+	copy(order, ss.agents)
+	rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+
+	for _, a := range order {
+		if a.dead {
+			continue
+		}
+		ss.move(a)
+		a.sugar += ss.sugar[a.y][a.x]
+		a.spice += ss.spice[a.y][a.x]
+		ss.sugar[a.y][a.x] = 0
+		ss.spice[a.y][a.x] = 0
+
+		a.sugar -= a.sugarMetabolism
+		a.spice -= a.spiceMetabolism
+		a.age++
+		if a.sugar < 0 || a.spice < 0 || a.age > a.lifespan {
+			ss.kill(a)
+		}
+	}
+
+	for _, a := range order {
+		if a.dead {
+			continue
+		}
+		if ss.params.Trade && ss.params.Spice {
+			ss.trade(a)
+		}
+		if ss.params.Reproduction {
+			ss.reproduce(a)
+		}
+	}
+
+	// Drop the dead and replace them if the rule is on
+	alive := ss.agents[:0]
+	died := 0
+	for _, a := range ss.agents {
+		if a.dead {
+			died++
+		} else {
+			dropDeadChildren(a)
+			alive = append(alive, a)
+		}
+	}
+	ss.agents = alive
+	if ss.params.Replacement {
+		for i := 0; i < died && len(ss.agents) < ss.grid.width*ss.grid.height; i++ {
+			x, y := ss.randomEmptyCell()
+			ss.place(ss.newAgent(), x, y)
+		}
+	}
+
+	for y := range ss.sugar {
+		for x := range ss.sugar[y] {
+			ss.sugar[y][x] = math.Min(ss.sugar[y][x]+ss.params.Growback, ss.sugarCapacity[y][x])
+			ss.spice[y][x] = math.Min(ss.spice[y][x]+ss.params.Growback, ss.spiceCapacity[y][x])
+		}
+	}
+
+	ss.step++
+	ss.gini = ss.Gini()
+	return nil
+}
+
+// wealth returns every agent's sugar in ascending order.
+func (ss *Sugarscape) wealth() []float64 {
+	w := make([]float64, len(ss.agents))
+	for i, a := range ss.agents {
+		w[i] = math.Max(a.sugar, 0)
+	}
+	sort.Float64s(w)
+	return w
+}
+
+// Gini returns the Gini coefficient of sugar wealth: 0 when everyone is
+// equally rich, approaching 1 when one agent holds everything.
+func (ss *Sugarscape) Gini() float64 {
+	w := ss.wealth()
+	n := float64(len(w))
+	total, weighted := 0.0, 0.0
+	for i, v := range w {
+		total += v
+		weighted += (2*float64(i+1) - n - 1) * v
+	}
+	if total == 0 {
+		return 0
+	}
+	return weighted / (n * total)
+}
+
+// LorenzCurve returns the cumulative share of wealth held by the poorest
+// fraction of agents, sampled at every agent. The first point is (0, 0).
+func (ss *Sugarscape) LorenzCurve() (population, wealth []float64) {
+	w := ss.wealth()
+	total := 0.0
+	for _, v := range w {
+		total += v
+	}
+
+	population = []float64{0}
+	wealth = []float64{0}
+	cumulative := 0.0
+	for i, v := range w {
+		cumulative += v
+		population = append(population, float64(i+1)/float64(len(w)))
+		if total > 0 {
+			wealth = append(wealth, cumulative/total)
+		} else {
+			wealth = append(wealth, population[i+1])
+		}
+	}
+	return population, wealth
+}
+
+func (ss *Sugarscape) WriteLorenzCSV(w io.Writer) error {
+	population, wealth := ss.LorenzCurve()
+	if _, err := fmt.Fprintln(w, "population_share,wealth_share"); err != nil {
+		return err
+	}
+	for i := range population {
+		if _, err := fmt.Fprintf(w, "%g,%g\n", population[i], wealth[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *Sugarscape) ExportLorenz(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ss.WriteLorenzCSV(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (ss *Sugarscape) siteColor(x, y int) color.Color {
+	sugar := ss.sugar[y][x] / float64(ss.params.MaxCapacity)
+	spice := ss.spice[y][x] / float64(ss.params.MaxCapacity)
+	return color.RGBA{
+		R: 255,
+		G: uint8(255 - 90*spice),
+		B: uint8(255 - 230*sugar),
+		A: 255,
+	}
+}
+
+func (ss *Sugarscape) Draw(screen *ebiten.Image) {
+	for y := 0; y < ss.grid.height; y++ {
+		for x := 0; x < ss.grid.width; x++ {
+			c := ss.siteColor(x, y)
+			if a := ss.occupant[y][x]; a != nil {
+				c = tribeColors[a.tribe]
+			}
+			ebitenutil.DrawRect(screen, float64(x*cellSize), float64(y*cellSize), cellSize, cellSize, c)
+		}
+	}
+
+	// Lorenz curve in the top-right corner, against the line of equality
+	size := float32(100)
+	left := float32(screen.Bounds().Dx()) - size - 10
+	top := float32(10)
+	vector.DrawFilledRect(screen, left, top, size, size, color.RGBA{0, 0, 0, 160}, false)
+	vector.StrokeLine(screen, left, top+size, left+size, top, 1, color.Gray{Y: 128}, false)
+	population, wealth := ss.LorenzCurve()
+	for i := 1; i < len(population); i++ {
+		vector.StrokeLine(screen,
+			left+float32(population[i-1])*size, top+size-float32(wealth[i-1])*size,
+			left+float32(population[i])*size, top+size-float32(wealth[i])*size,
+			1, color.White, false)
+	}
+
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("%s  Step: %d  Population: %d  Gini: %.3f", ss.params.Name, ss.step, len(ss.agents), ss.gini))
+	if ss.IsPaused() {
+		ebitenutil.DebugPrintAt(screen, "Paused", screen.Bounds().Dx()/2-30, screen.Bounds().Dy()/2)
+	}
+}
+
+func (ss *Sugarscape) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return ss.grid.width * cellSize, ss.grid.height * cellSize
+}
@@ -1,12 +1,15 @@
 package simulation
 
 import (
+	"fmt"
 	"image/color"
+	"log"
+	"math"
 	"math/rand"
 
-	"github.com/aquilax/go-perlin"
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 )
 
 type Biome struct {
@@ -18,76 +21,76 @@ var (
 	PlainsBiome = Biome{
 		name: "Plains",
 		terrainColors: []terrainColor{
-			{color: color.RGBA{0, 0, 139, 255}, threshold: 0.2},      // Deep Water
-			{color: color.RGBA{0, 0, 255, 255}, threshold: 0.4},      // Water
-			{color: color.RGBA{238, 214, 175, 255}, threshold: 0.45}, // Sand
-			{color: color.RGBA{34, 139, 34, 255}, threshold: 0.6},    // Grass
-			{color: color.RGBA{0, 100, 0, 255}, threshold: 0.7},      // High Grass
-			{color: color.RGBA{34, 139, 34, 255}, threshold: 0.8},    // Forest
-			{color: color.RGBA{139, 69, 19, 255}, threshold: 0.9},    // Mountain
-			{color: color.RGBA{255, 250, 250, 255}, threshold: 1.0},  // Snow
+			{label: "Deep Water", color: color.RGBA{0, 0, 139, 255}, threshold: 0.2, class: TerrainWater},
+			{label: "Water", color: color.RGBA{0, 0, 255, 255}, threshold: 0.4, class: TerrainWater},
+			{label: "Sand", color: color.RGBA{238, 214, 175, 255}, threshold: 0.45, class: TerrainSand},
+			{label: "Grass", color: color.RGBA{34, 139, 34, 255}, threshold: 0.6, class: TerrainGrass},
+			{label: "High Grass", color: color.RGBA{0, 100, 0, 255}, threshold: 0.7, class: TerrainGrass},
+			{label: "Forest", color: color.RGBA{34, 139, 34, 255}, threshold: 0.8, class: TerrainForest},
+			{label: "Mountain", color: color.RGBA{139, 69, 19, 255}, threshold: 0.9, class: TerrainRock},
+			{label: "Snow", color: color.RGBA{255, 250, 250, 255}, threshold: 1.0, class: TerrainSnow},
 		},
 	}
 
 	DesertBiome = Biome{
 		name: "Desert",
 		terrainColors: []terrainColor{
-			{color: color.RGBA{255, 235, 205, 255}, threshold: 0.3},  // Light Sand
-			{color: color.RGBA{238, 214, 175, 255}, threshold: 0.6},  // Sand
-			{color: color.RGBA{210, 180, 140, 255}, threshold: 0.75}, // Dunes
-			{color: color.RGBA{160, 82, 45, 255}, threshold: 0.9},    // Rocky Sand
-			{color: color.RGBA{139, 69, 19, 255}, threshold: 1.0},    // Rocky Outcrops
+			{label: "Light Sand", color: color.RGBA{255, 235, 205, 255}, threshold: 0.3, class: TerrainSand},
+			{label: "Sand", color: color.RGBA{238, 214, 175, 255}, threshold: 0.6, class: TerrainSand},
+			{label: "Dunes", color: color.RGBA{210, 180, 140, 255}, threshold: 0.75, class: TerrainSand},
+			{label: "Rocky Sand", color: color.RGBA{160, 82, 45, 255}, threshold: 0.9, class: TerrainRock},
+			{label: "Rocky Outcrops", color: color.RGBA{139, 69, 19, 255}, threshold: 1.0, class: TerrainRock},
 		},
 	}
 
 	TundraBiome = Biome{
 		name: "Tundra",
 		terrainColors: []terrainColor{
-			{color: color.RGBA{0, 0, 139, 255}, threshold: 0.2},      // Deep Water
-			{color: color.RGBA{0, 0, 255, 255}, threshold: 0.4},      // Water
-			{color: color.RGBA{240, 255, 240, 255}, threshold: 0.5},  // Ice
-			{color: color.RGBA{224, 255, 255, 255}, threshold: 0.6},  // Snowy Grass
-			{color: color.RGBA{176, 196, 222, 255}, threshold: 0.75}, // Frozen Tundra
-			{color: color.RGBA{255, 250, 250, 255}, threshold: 1.0},  // Snow
+			{label: "Deep Water", color: color.RGBA{0, 0, 139, 255}, threshold: 0.2, class: TerrainWater},
+			{label: "Water", color: color.RGBA{0, 0, 255, 255}, threshold: 0.4, class: TerrainWater},
+			{label: "Ice", color: color.RGBA{240, 255, 240, 255}, threshold: 0.5, class: TerrainSnow},
+			{label: "Snowy Grass", color: color.RGBA{224, 255, 255, 255}, threshold: 0.6, class: TerrainGrass},
+			{label: "Frozen Tundra", color: color.RGBA{176, 196, 222, 255}, threshold: 0.75, class: TerrainGrass},
+			{label: "Snow", color: color.RGBA{255, 250, 250, 255}, threshold: 1.0, class: TerrainSnow},
 		},
 	}
 
 	MountainousBiome = Biome{
 		name: "Mountainous",
 		terrainColors: []terrainColor{
-			{color: color.RGBA{0, 0, 139, 255}, threshold: 0.2},     // Deep Water
-			{color: color.RGBA{0, 0, 255, 255}, threshold: 0.3},     // Water
-			{color: color.RGBA{160, 82, 45, 255}, threshold: 0.5},   // Rocky Terrain
-			{color: color.RGBA{139, 69, 19, 255}, threshold: 0.7},   // Mountain Base
-			{color: color.RGBA{105, 105, 105, 255}, threshold: 0.8}, // Mountain
-			{color: color.RGBA{169, 169, 169, 255}, threshold: 0.9}, // High Mountain
-			{color: color.RGBA{255, 250, 250, 255}, threshold: 1.0}, // Snow Capped Peaks
+			{label: "Deep Water", color: color.RGBA{0, 0, 139, 255}, threshold: 0.2, class: TerrainWater},
+			{label: "Water", color: color.RGBA{0, 0, 255, 255}, threshold: 0.3, class: TerrainWater},
+			{label: "Rocky Terrain", color: color.RGBA{160, 82, 45, 255}, threshold: 0.5, class: TerrainRock},
+			{label: "Mountain Base", color: color.RGBA{139, 69, 19, 255}, threshold: 0.7, class: TerrainRock},
+			{label: "Mountain", color: color.RGBA{105, 105, 105, 255}, threshold: 0.8, class: TerrainRock},
+			{label: "High Mountain", color: color.RGBA{169, 169, 169, 255}, threshold: 0.9, class: TerrainRock},
+			{label: "Snow Capped Peaks", color: color.RGBA{255, 250, 250, 255}, threshold: 1.0, class: TerrainSnow},
 		},
 	}
 
 	ForestBiome = Biome{
 		name: "Forest",
 		terrainColors: []terrainColor{
-			{color: color.RGBA{0, 100, 0, 255}, threshold: 0.2},     // Deep Forest
-			{color: color.RGBA{34, 139, 34, 255}, threshold: 0.5},   // Dense Forest
-			{color: color.RGBA{107, 142, 35, 255}, threshold: 0.7},  // Light Forest
-			{color: color.RGBA{85, 107, 47, 255}, threshold: 0.8},   // Forest Edge
-			{color: color.RGBA{154, 205, 50, 255}, threshold: 0.9},  // Grassland
-			{color: color.RGBA{238, 214, 175, 255}, threshold: 1.0}, // Forest Path
+			{label: "Deep Forest", color: color.RGBA{0, 100, 0, 255}, threshold: 0.2, class: TerrainForest},
+			{label: "Dense Forest", color: color.RGBA{34, 139, 34, 255}, threshold: 0.5, class: TerrainForest},
+			{label: "Light Forest", color: color.RGBA{107, 142, 35, 255}, threshold: 0.7, class: TerrainForest},
+			{label: "Forest Edge", color: color.RGBA{85, 107, 47, 255}, threshold: 0.8, class: TerrainForest},
+			{label: "Grassland", color: color.RGBA{154, 205, 50, 255}, threshold: 0.9, class: TerrainGrass},
+			{label: "Forest Path", color: color.RGBA{238, 214, 175, 255}, threshold: 1.0, class: TerrainSand},
 		},
 	}
 
 	WorldOfWarcraftBiome = Biome{
 		name: "World of Warcraft",
 		terrainColors: []terrainColor{
-			{color: color.RGBA{72, 61, 139, 255}, threshold: 0.2},    // Deep Sea
-			{color: color.RGBA{65, 105, 225, 255}, threshold: 0.4},   // Ocean
-			{color: color.RGBA{255, 222, 173, 255}, threshold: 0.45}, // Coastal Sand
-			{color: color.RGBA{34, 139, 34, 255}, threshold: 0.6},    // Grasslands
-			{color: color.RGBA{139, 69, 19, 255}, threshold: 0.7},    // Barrens
-			{color: color.RGBA{210, 105, 30, 255}, threshold: 0.8},   // Savanna
-			{color: color.RGBA{112, 128, 144, 255}, threshold: 0.9},  // Storm Peaks
-			{color: color.RGBA{255, 250, 250, 255}, threshold: 1.0},  // Snow Peaks
+			{label: "Deep Sea", color: color.RGBA{72, 61, 139, 255}, threshold: 0.2, class: TerrainWater},
+			{label: "Ocean", color: color.RGBA{65, 105, 225, 255}, threshold: 0.4, class: TerrainWater},
+			{label: "Coastal Sand", color: color.RGBA{255, 222, 173, 255}, threshold: 0.45, class: TerrainSand},
+			{label: "Grasslands", color: color.RGBA{34, 139, 34, 255}, threshold: 0.6, class: TerrainGrass},
+			{label: "Barrens", color: color.RGBA{139, 69, 19, 255}, threshold: 0.7, class: TerrainGrass},
+			{label: "Savanna", color: color.RGBA{210, 105, 30, 255}, threshold: 0.8, class: TerrainGrass},
+			{label: "Storm Peaks", color: color.RGBA{112, 128, 144, 255}, threshold: 0.9, class: TerrainRock},
+			{label: "Snow Peaks", color: color.RGBA{255, 250, 250, 255}, threshold: 1.0, class: TerrainSnow},
 		},
 	}
 )
@@ -95,7 +98,10 @@ var (
 type Terrain struct {
 	BaseSimulation
 	grid         *Grid
-	noise        *perlin.Perlin
+	heights      [][]float64 // Normalized 0-1 height of every cell, kept for export
+	noise        NoiseSource
+	noiseType    NoiseType
+	customNoise  NoiseSource // Set by SetNoise, replaces the built-in noise types
 	seed         int64
 	freq         float64
 	alpha        float64
@@ -104,11 +110,52 @@ type Terrain struct {
 	time         float64
 	biomes       []Biome
 	currentBiome int
+
+	// Whittaker classification, see terrain_climate.go
+	whittaker        bool
+	climate          ClimateParams
+	temperature      [][]float64
+	moisture         [][]float64
+	temperatureNoise NoiseSource
+	moistureNoise    NoiseSource
+
+	// Lighting and outlines, see terrain_shading.go
+	shading ShadingParams
+
+	// Island and continent masks, see terrain_mask.go
+	maskParams MaskParams
+	mask       [][]float64 // Land mask per cell, nil without one
+	maskPreset int
+
+	// Route planner, see terrain_path.go; nil unless planning
+	paths    *pathOverlay
+	revision int // Counts changes to the heights and classes, so the planner knows when to refresh
+
+	// While an erosion run or a river network is active the height field is
+	// no longer regenerated from noise
+	erosion   *Erosion
+	hydrology *Hydrology
+	rivers    [][]RiverPoint // Traced from the hydrology for drawing
 }
 
+type TerrainClass int
+
+// The terrain class says what a band is made of, independent of its color, so
+// models running on top of the terrain can tell forest from rock.
+const (
+	TerrainWater TerrainClass = iota
+	TerrainSand
+	TerrainGrass
+	TerrainForest
+	TerrainRock
+	TerrainSnow
+)
+
 type terrainColor struct {
+	label     string
 	color     color.Color
 	threshold float64
+	class     TerrainClass
 }
 
 func GetBiomes() []Biome {
@@ -124,11 +171,19 @@ func GetBiomes() []Biome {
 
 func NewTerrain(width, height int, seed int64, biomes []Biome) *Terrain {
 	grid := NewGrid(width, height)
-	p := perlin.NewPerlin(2, 2, 3, seed)
+
+	heights := make([][]float64, height)
+	temperature := make([][]float64, height)
+	moisture := make([][]float64, height)
+	for y := range heights {
+		heights[y] = make([]float64, width)
+		temperature[y] = make([]float64, width)
+		moisture[y] = make([]float64, width)
+	}
 
 	t := &Terrain{
 		grid:         grid,
-		noise:        p,
+		heights:      heights,
 		seed:         seed,
 		freq:         0.05,
 		alpha:        2,
@@ -137,34 +192,263 @@ func NewTerrain(width, height int, seed int64, biomes []Biome) *Terrain {
 		time:         0,
 		biomes:       biomes,
 		currentBiome: 0,
+		climate:      DefaultClimateParams,
+		shading:      DefaultShadingParams,
+		maskParams:   DefaultMaskParams,
+		temperature:  temperature,
+		moisture:     moisture,
 	}
 
+	t.rebuildNoise()
+	t.reseedClimate()
 	t.generateTerrain()
 	return t
 }
+
 func (t *Terrain) generateTerrain() {
+	for y := range t.heights {
+		for x := range t.heights[y] {
+			t.heights[y][x] = t.sampleHeight(x, y)
+		}
+	}
+	t.applyMask()
+	t.reclassify()
+}
+
+// reclassify recolors the terrain after its heights or classes have changed,
+// so the route planner knows its costs are out of date.
+func (t *Terrain) reclassify() {
+	t.revision++
+	t.colorize()
+}
+
+// colorize recolors every cell from the current height field.
+func (t *Terrain) colorize() {
 	biome := t.biomes[t.currentBiome]
 	for y := range t.grid.cells {
 		for x := range t.grid.cells[y] {
-			noiseValue := t.noise.Noise3D(float64(x)*t.freq, float64(y)*t.freq, t.time)
-			noiseValue = (noiseValue + 1) / 2 // Normalize to 0-1
-
-			for _, terrain := range biome.terrainColors {
-				if noiseValue <= terrain.threshold {
-					t.grid.cells[y][x] = terrain.color
-					break
-				}
+			t.sampleClimate(x, y)
+			var base color.Color
+			if t.hydrology != nil && t.hydrology.Lake[y][x] {
+				base = lakeColor
+			} else if t.whittaker {
+				base = t.whittakerAt(x, y).Color
+			} else if t.shading.Smooth {
+				base = biome.smoothColor(t.heights[y][x])
+			} else if band, ok := biome.band(t.heights[y][x]); ok {
+				base = band.color
+			} else {
+				continue
 			}
+			t.grid.cells[y][x] = t.shade(x, y, base)
 		}
 	}
 }
 
+// rebuildNoise recreates the height noise from the terrain's seed, noise
+// type and octave parameters.
+func (t *Terrain) rebuildNoise() {
+	if t.customNoise != nil {
+		t.noise = t.customNoise
+		return
+	}
+	t.noise = NewNoise(t.noiseType, t.alpha, t.beta, t.octaves, t.seed)
+}
+
+// SetNoiseType switches the height field to one of the built-in noise types.
+func (t *Terrain) SetNoiseType(kind NoiseType) {
+	t.noiseType = kind
+	t.customNoise = nil
+	t.rebuildNoise()
+	t.discardHeightEdits()
+	t.generateTerrain()
+}
+
+// SetNoise replaces the height noise with any source, such as a hand-built
+// graph. It is sampled at cell coordinates times the terrain's frequency,
+// with time as the third coordinate.
+func (t *Terrain) SetNoise(source NoiseSource) {
+	t.customNoise = source
+	t.rebuildNoise()
+	t.discardHeightEdits()
+	t.generateTerrain()
+}
+
+// discardHeightEdits drops any erosion run and river network before the
+// height field is regenerated, since both belong to the old field.
+func (t *Terrain) discardHeightEdits() {
+	t.erosion = nil
+	t.hydrology = nil
+	t.rivers = nil
+}
+
+func (t *Terrain) sampleHeight(x, y int) float64 {
+	noiseValue := t.noise.Noise3D(float64(x)*t.freq, float64(y)*t.freq, t.time)
+	return (noiseValue + 1) / 2 // Normalize to 0-1
+}
+
+// heightAt returns the height of a cell as of the last generation.
+func (t *Terrain) heightAt(x, y int) float64 {
+	return t.heights[y][x]
+}
+
+// band returns the first terrain band whose threshold covers the height.
+func (b Biome) band(height float64) (terrainColor, bool) {
+	for _, terrain := range b.terrainColors {
+		if height <= terrain.threshold {
+			return terrain, true
+		}
+	}
+	return terrainColor{}, false
+}
+
+// classAt returns the terrain class of a cell in the current biome, or in its
+// Whittaker biome when classifying by climate.
+func (t *Terrain) classAt(x, y int) TerrainClass {
+	if t.whittaker {
+		return t.whittakerAt(x, y).Class
+	}
+	band, _ := t.biomes[t.currentBiome].band(t.heightAt(x, y))
+	return band.class
+}
+
+// bandColor returns the unlit color of a cell's band in the current biome, or
+// of its Whittaker biome when classifying by climate, leaving out shading
+// and lakes.
+func (t *Terrain) bandColor(x, y int) color.Color {
+	if t.whittaker {
+		return t.whittakerAt(x, y).Color
+	}
+	if band, ok := t.biomes[t.currentBiome].band(t.heightAt(x, y)); ok {
+		return band.color
+	}
+	return color.Black
+}
+
 func (t *Terrain) Update() error {
-	t.UpdatePauseState()
+	// Press P to plan routes: the terrain holds still and left clicks pick
+	// the ends of a path instead of pausing
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		if t.paths == nil {
+			t.paths = &pathOverlay{params: DefaultPathParams, revision: t.revision}
+		} else {
+			t.paths = nil
+		}
+	}
+	if t.paths == nil {
+		t.UpdatePauseState()
+	}
+
+	// Press E to export the current height field to the working directory
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		if err := t.ExportHeightmap(".", "terrain"); err != nil {
+			log.Printf("exporting heightmap: %v", err)
+		}
+		if t.hydrology != nil {
+			if err := t.ExportRivers("terrain_rivers.geojson"); err != nil {
+				log.Printf("exporting rivers: %v", err)
+			}
+		}
+		if t.paths != nil && t.paths.route != nil {
+			if err := t.ExportPath("terrain_path.csv"); err != nil {
+				log.Printf("exporting path: %v", err)
+			}
+		}
+	}
+
+	// Press S to save the biomes, including threshold edits, to biomes.json
+	if inpututil.IsKeyJustPressed(ebiten.KeyS) {
+		if err := SaveBiomes("biomes.json", t.biomes); err != nil {
+			log.Printf("saving biomes: %v", err)
+		}
+	}
+
+	// Press L, C or I to toggle hillshading, contour lines and smooth
+	// color bands, and the arrow keys to move the sun
+	shading := t.shading
+	if inpututil.IsKeyJustPressed(ebiten.KeyL) {
+		shading.Hillshade = !shading.Hillshade
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyC) {
+		shading.Contours = !shading.Contours
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyI) {
+		shading.Smooth = !shading.Smooth
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyArrowLeft) {
+		shading.SunAzimuth = math.Mod(shading.SunAzimuth+355, 360)
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyArrowRight) {
+		shading.SunAzimuth = math.Mod(shading.SunAzimuth+5, 360)
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyArrowUp) {
+		shading.SunAltitude = math.Min(shading.SunAltitude+2, 90)
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyArrowDown) {
+		shading.SunAltitude = math.Max(shading.SunAltitude-2, 5)
+	}
+	if shading != t.shading {
+		t.SetShading(shading)
+	}
+
+	// Press M to cycle between no mask, an island, an archipelago and a
+	// continent
+	if inpututil.IsKeyJustPressed(ebiten.KeyM) {
+		t.maskPreset = (t.maskPreset + 1) % len(maskPresets)
+		t.SetMask(maskPresets[t.maskPreset])
+	}
+
+	// Press N to cycle through the noise types
+	if inpututil.IsKeyJustPressed(ebiten.KeyN) {
+		t.SetNoiseType((t.noiseType + 1) % (WarpedNoiseType + 1))
+	}
+
+	// Press W to switch between height bands and climate biomes
+	if inpututil.IsKeyJustPressed(ebiten.KeyW) {
+		t.UseWhittaker(!t.whittaker)
+	}
+
+	// Press D, G or H to erode the current terrain with droplets, the grid
+	// model or thermal erosion, and X to go back to the animated noise
+	for key, method := range map[ebiten.Key]ErosionMethod{
+		ebiten.KeyD: DropletErosion,
+		ebiten.KeyG: GridErosion,
+		ebiten.KeyH: ThermalErosion,
+	} {
+		if inpututil.IsKeyJustPressed(key) {
+			t.Erode(method, ErosionParamsFor(method))
+		}
+	}
+
+	// Press R to carve rivers and fill lakes on the current terrain
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		t.ComputeRivers(DefaultHydrologyParams)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyX) {
+		t.erosion = nil
+		t.hydrology = nil
+	}
+
+	if t.paths != nil {
+		t.updatePaths()
+		return nil
+	}
 	if t.IsPaused() {
 		return nil
 	}
 
+	// Erosion advances one batch per update until it is done
+	if t.erosion != nil {
+		if !t.erosion.Done() {
+			t.erosion.Step()
+			t.reclassify()
+		}
+		return nil
+	}
+	if t.hydrology != nil {
+		return nil
+	}
+
 	t.time += 0.01 // Adjust this value to control the speed of the movement
 
 	// Check for right-click to change the biome
@@ -173,7 +457,9 @@ func (t *Terrain) Update() error {
 	}
 
 	// Check for mouse wheel scroll to adjust terrain thresholds
-	t.handleMouseWheel()
+	if !t.whittaker {
+		t.handleMouseWheel()
+	}
 
 	t.generateTerrain()
 	return nil
@@ -186,25 +472,29 @@ func (t *Terrain) handleMouseWheel() {
 
 	if gridX >= 0 && gridX < t.grid.width && gridY >= 0 && gridY < t.grid.height {
 		biome := &t.biomes[t.currentBiome]
-		noiseValue := t.noise.Noise3D(float64(gridX)*t.freq, float64(gridY)*t.freq, t.time)
-		noiseValue = (noiseValue + 1) / 2 // Normalize to 0-1
+		noiseValue := t.heightAt(gridX, gridY)
+
+		bands := biome.terrainColors
+		for i := range bands {
+			if noiseValue <= bands[i].threshold {
+				// The last band always ends at 1.0
+				if i == len(bands)-1 {
+					break
+				}
 
-		for i := range biome.terrainColors {
-			if noiseValue <= biome.terrainColors[i].threshold {
 				// Get the mouse wheel offsets
 				_, yoff := ebiten.Wheel()
 
-				// Adjust threshold based on mouse wheel input
+				// Adjust threshold based on mouse wheel input, keeping it
+				// between its neighbors so the bands stay in order
+				low, high := 0.01, bands[i+1].threshold-0.01
+				if i > 0 {
+					low = bands[i-1].threshold + 0.01
+				}
 				if yoff > 0 {
-					biome.terrainColors[i].threshold += 0.01
-					if biome.terrainColors[i].threshold > 1.0 {
-						biome.terrainColors[i].threshold = 1.0
-					}
+					bands[i].threshold = math.Min(bands[i].threshold+0.01, high)
 				} else if yoff < 0 {
-					biome.terrainColors[i].threshold -= 0.01
-					if biome.terrainColors[i].threshold < 0.0 {
-						biome.terrainColors[i].threshold = 0.0
-					}
+					bands[i].threshold = math.Max(bands[i].threshold-0.01, low)
 				}
 				break
 			}
@@ -215,7 +505,8 @@ func (t *Terrain) handleMouseWheel() {
 func (t *Terrain) changeBiome() {
 	t.currentBiome = (t.currentBiome + 1) % len(t.biomes)
 	t.seed = rand.Int63() // Randomize seed for new biome
-	t.noise = perlin.NewPerlin(2, 2, 3, t.seed)
+	t.rebuildNoise()
+	t.reseedClimate()
 }
 
 func (t *Terrain) Draw(screen *ebiten.Image) {
@@ -225,8 +516,51 @@ func (t *Terrain) Draw(screen *ebiten.Image) {
 		}
 	}
 
-	// Display the name of the current biome
-	ebitenutil.DebugPrintAt(screen, "Biome: "+t.biomes[t.currentBiome].name, 10, 10)
+	if t.hydrology != nil {
+		t.drawRivers(screen)
+	}
+	if t.paths != nil {
+		t.drawPaths(screen)
+	}
+
+	// Display the name of the current biome, or of the climate biome under
+	// the cursor
+	if t.whittaker {
+		label := "Biome: Whittaker"
+		x, y := ebiten.CursorPosition()
+		if gx, gy := x/cellSize, y/cellSize; x >= 0 && y >= 0 && gx < t.grid.width && gy < t.grid.height {
+			label = fmt.Sprintf("Biome: %s (temperature %.2f, moisture %.2f)", t.whittakerAt(gx, gy).Name, t.temperature[gy][gx], t.moisture[gy][gx])
+		}
+		ebitenutil.DebugPrintAt(screen, label, 10, 10)
+	} else {
+		label := "Biome: " + t.biomes[t.currentBiome].name
+		x, y := ebiten.CursorPosition()
+		if gx, gy := x/cellSize, y/cellSize; x >= 0 && y >= 0 && gx < t.grid.width && gy < t.grid.height {
+			if band, ok := t.biomes[t.currentBiome].band(t.heights[gy][gx]); ok {
+				label += " (" + band.label + ")"
+			}
+		}
+		ebitenutil.DebugPrintAt(screen, label, 10, 10)
+	}
+	noiseName := t.noiseType.String()
+	if t.customNoise != nil {
+		noiseName = "custom"
+	}
+	if t.maskParams.Shape != NoMask {
+		noiseName += "  Mask: " + t.maskParams.Shape.String()
+	}
+	if t.shading.Hillshade {
+		noiseName += fmt.Sprintf("  Sun: %.0f° at %.0f°", t.shading.SunAzimuth, t.shading.SunAltitude)
+	}
+	ebitenutil.DebugPrintAt(screen, "Noise: "+noiseName, 10, 40)
+	if t.erosion != nil {
+		ebitenutil.DebugPrintAt(screen, t.erosion.Progress(), 10, 25)
+	} else if t.hydrology != nil {
+		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Rivers: %d", len(t.rivers)), 10, 25)
+	}
+	if t.paths != nil {
+		ebitenutil.DebugPrintAt(screen, t.pathStatus(), 10, 55)
+	}
 
 	if t.IsPaused() {
 		ebitenutil.DebugPrintAt(screen, "Paused", screen.Bounds().Dx()/2-30, screen.Bounds().Dy()/2)
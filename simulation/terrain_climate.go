@@ -0,0 +1,115 @@
+package simulation
+
+import (
+	"image/color"
+	"math"
+)
+
+// ClimateParams shape the temperature and moisture fields used to classify
+// cells into Whittaker biomes. All fields are normalized to 0-1.
+type ClimateParams struct {
+	SeaLevel       float64 // Cells at or below this height are ocean
+	BeachLevel     float64 // Cells between sea level and this height are beach
+	LatitudeWeight float64 // How much of the temperature comes from latitude rather than noise
+	LapseRate      float64 // Temperature lost between sea level and the highest peak
+	ClimateFreq    float64 // Noise frequency of the temperature and moisture fields
+}
+
+var DefaultClimateParams = ClimateParams{
+	SeaLevel:       0.4,
+	BeachLevel:     0.43,
+	LatitudeWeight: 0.7,
+	LapseRate:      0.4,
+	ClimateFreq:    0.02,
+}
+
+type WhittakerBiome struct {
+	Name  string
+	Color color.RGBA
+	Class TerrainClass
+}
+
+var (
+	WhittakerOcean                  = WhittakerBiome{"Ocean", color.RGBA{68, 68, 122, 255}, TerrainWater}
+	WhittakerBeach                  = WhittakerBiome{"Beach", color.RGBA{160, 144, 119, 255}, TerrainSand}
+	WhittakerSnow                   = WhittakerBiome{"Snow", color.RGBA{248, 248, 248, 255}, TerrainSnow}
+	WhittakerTundra                 = WhittakerBiome{"Tundra", color.RGBA{221, 221, 187, 255}, TerrainGrass}
+	WhittakerBare                   = WhittakerBiome{"Bare", color.RGBA{187, 187, 187, 255}, TerrainRock}
+	WhittakerScorched               = WhittakerBiome{"Scorched", color.RGBA{153, 153, 153, 255}, TerrainRock}
+	WhittakerTaiga                  = WhittakerBiome{"Taiga", color.RGBA{153, 170, 119, 255}, TerrainForest}
+	WhittakerShrubland              = WhittakerBiome{"Shrubland", color.RGBA{136, 153, 119, 255}, TerrainGrass}
+	WhittakerTemperateDesert        = WhittakerBiome{"Temperate Desert", color.RGBA{201, 210, 155, 255}, TerrainSand}
+	WhittakerTemperateRainForest    = WhittakerBiome{"Temperate Rain Forest", color.RGBA{68, 136, 85, 255}, TerrainForest}
+	WhittakerTemperateDeciduous     = WhittakerBiome{"Temperate Deciduous Forest", color.RGBA{103, 148, 89, 255}, TerrainForest}
+	WhittakerGrassland              = WhittakerBiome{"Grassland", color.RGBA{136, 170, 85, 255}, TerrainGrass}
+	WhittakerTropicalRainForest     = WhittakerBiome{"Tropical Rain Forest", color.RGBA{51, 119, 85, 255}, TerrainForest}
+	WhittakerTropicalSeasonalForest = WhittakerBiome{"Tropical Seasonal Forest", color.RGBA{85, 153, 68, 255}, TerrainForest}
+	WhittakerSubtropicalDesert      = WhittakerBiome{"Subtropical Desert", color.RGBA{210, 185, 139, 255}, TerrainSand}
+)
+
+// whittakerTable is indexed [temperature band][moisture band], from cold to
+// hot and from dry to wet.
+var whittakerTable = [4][6]WhittakerBiome{
+	{WhittakerScorched, WhittakerBare, WhittakerTundra, WhittakerSnow, WhittakerSnow, WhittakerSnow},
+	{WhittakerTemperateDesert, WhittakerTemperateDesert, WhittakerShrubland, WhittakerShrubland, WhittakerTaiga, WhittakerTaiga},
+	{WhittakerTemperateDesert, WhittakerGrassland, WhittakerGrassland, WhittakerTemperateDeciduous, WhittakerTemperateDeciduous, WhittakerTemperateRainForest},
+	{WhittakerSubtropicalDesert, WhittakerGrassland, WhittakerTropicalSeasonalForest, WhittakerTropicalSeasonalForest, WhittakerTropicalRainForest, WhittakerTropicalRainForest},
+}
+
+// ClassifyWhittaker picks the biome for a cell from its height, temperature
+// and moisture.
+func ClassifyWhittaker(height, temperature, moisture float64, climate ClimateParams) WhittakerBiome {
+	switch {
+	case height <= climate.SeaLevel:
+		return WhittakerOcean
+	case height <= climate.BeachLevel:
+		return WhittakerBeach
+	}
+	row := min(int(clamp01(temperature)*float64(len(whittakerTable))), len(whittakerTable)-1)
+	col := min(int(clamp01(moisture)*float64(len(whittakerTable[row]))), len(whittakerTable[row])-1)
+	return whittakerTable[row][col]
+}
+
+// UseWhittaker switches between classifying cells by climate and coloring
+// them from the current biome's height bands.
+func (t *Terrain) UseWhittaker(on bool) {
+	t.whittaker = on
+	t.reclassify()
+}
+
+// climateContrast stretches the climate noise, which rarely strays far from
+// zero, so the driest, wettest and hottest columns of the table get used.
+const climateContrast = 1.6
+
+func (t *Terrain) reseedClimate() {
+	t.temperatureNoise = NewPerlinNoise(2, 2, 3, t.seed+1)
+	t.moistureNoise = NewPerlinNoise(2, 2, 3, t.seed+2)
+}
+
+// sampleClimate fills the temperature and moisture of a cell. Temperature
+// falls from the equator across the middle of the map towards both poles,
+// and with altitude above sea level.
+func (t *Terrain) sampleClimate(x, y int) {
+	c := t.climate
+	fx, fy := float64(x)*c.ClimateFreq, float64(y)*c.ClimateFreq
+
+	latitude := 0.0
+	if t.grid.height > 1 {
+		latitude = math.Abs(2*float64(y)/float64(t.grid.height-1) - 1)
+	}
+	noise := (t.temperatureNoise.Noise3D(fx, fy, 0)*climateContrast + 1) / 2
+	temperature := c.LatitudeWeight*(1-latitude) + (1-c.LatitudeWeight)*noise
+
+	if altitude := t.heights[y][x] - c.SeaLevel; altitude > 0 {
+		temperature -= c.LapseRate * altitude / (1 - c.SeaLevel)
+	}
+
+	t.temperature[y][x] = clamp01(temperature)
+	t.moisture[y][x] = clamp01((t.moistureNoise.Noise3D(fx, fy, 0)*climateContrast + 1) / 2)
+}
+
+// whittakerAt returns the Whittaker biome of a cell as of the last
+// generation.
+func (t *Terrain) whittakerAt(x, y int) WhittakerBiome {
+	return ClassifyWhittaker(t.heights[y][x], t.temperature[y][x], t.moisture[y][x], t.climate)
+}
@@ -0,0 +1,429 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+type ErosionMethod int
+
+const (
+	// DropletErosion rolls individual rain droplets downhill, each picking up
+	// and dropping sediment along its own path
+	DropletErosion ErosionMethod = iota
+	// GridErosion simulates water depth, flow and suspended sediment on every
+	// cell at once with the virtual pipe model
+	GridErosion
+	// ThermalErosion crumbles slopes steeper than the talus angle onto their
+	// lower neighbors
+	ThermalErosion
+)
+
+func (m ErosionMethod) String() string {
+	switch m {
+	case DropletErosion:
+		return "droplet"
+	case GridErosion:
+		return "grid"
+	case ThermalErosion:
+		return "thermal"
+	}
+	return "unknown"
+}
+
+// ErosionParams are shared by all three methods where they make sense.
+// Heights are the terrain's 0-1 values with cells one unit apart.
+type ErosionParams struct {
+	Iterations       int     // Droplets for droplet erosion, passes for grid and thermal erosion
+	StepSize         int     // Iterations run per update when watching step by step
+	SedimentCapacity float64 // How much sediment moving water can carry per unit of slope and speed
+	Evaporation      float64 // Fraction of water lost per droplet step or grid pass
+	Deposition       float64 // Fraction of excess sediment dropped per step
+	ErosionRate      float64 // Fraction of spare capacity filled from the ground per step
+
+	// Droplet erosion
+	Inertia     float64 // How much a droplet keeps its direction instead of following the slope
+	Gravity     float64
+	MinCapacity float64 // Keeps droplets eroding a little on flat ground
+	MaxLifetime int     // Steps before a droplet is discarded
+
+	// Grid erosion
+	Rain      float64 // Water added to every cell per pass
+	TimeStep  float64
+	MinTilt   float64 // Lower bound on the slope term, so flat water still erodes
+	PipeScale float64 // Vertical exaggeration used for water pressure and slopes
+	MaxSpeed  float64 // Caps the flow speed, which blows up where the water is very shallow
+	MinDepth  float64 // Water shallower than this carries proportionally less sediment
+
+	// Thermal erosion
+	Talus       float64 // Largest stable height difference between neighbors
+	ThermalRate float64 // Fraction of the excess moved per pass
+}
+
+var DefaultErosionParams = ErosionParams{
+	Iterations:       20000,
+	StepSize:         1000,
+	SedimentCapacity: 4,
+	Evaporation:      0.01,
+	Deposition:       0.3,
+	ErosionRate:      0.3,
+
+	Inertia:     0.05,
+	Gravity:     4,
+	MinCapacity: 0.001,
+	MaxLifetime: 30,
+
+	Rain:      0.01,
+	TimeStep:  0.1,
+	MinTilt:   0.05,
+	PipeScale: 20,
+	MaxSpeed:  1,
+	MinDepth:  0.05,
+
+	Talus:       0.02,
+	ThermalRate: 0.5,
+}
+
+// ErosionParamsFor returns DefaultErosionParams tuned to the method. One grid
+// or thermal pass covers every cell while a droplet only touches a short
+// path, and the grid model needs a lower capacity to stay stable.
+func ErosionParamsFor(method ErosionMethod) ErosionParams {
+	params := DefaultErosionParams
+	switch method {
+	case GridErosion:
+		params.Iterations, params.StepSize = 1000, 10
+		params.SedimentCapacity = 0.5
+	case ThermalErosion:
+		params.Iterations, params.StepSize = 200, 2
+	}
+	return params
+}
+
+// Erosion wears down a height field in place, a batch of iterations at a
+// time so it can be watched.
+type Erosion struct {
+	Method ErosionMethod
+	Params ErosionParams
+
+	heights [][]float64
+	width   int
+	height  int
+	rng     *rand.Rand
+	done    int
+
+	// Grid erosion state, indexed y*width+x
+	water    []float64
+	sediment []float64
+	moved    []float64
+	flux     [][4]float64 // Outflow towards left, right, up and down
+	vx, vy   []float64
+}
+
+func NewErosion(heights [][]float64, method ErosionMethod, params ErosionParams, seed int64) *Erosion {
+	e := &Erosion{
+		Method:  method,
+		Params:  params,
+		heights: heights,
+		height:  len(heights),
+		rng:     rand.New(rand.NewSource(seed)),
+	}
+	if e.height > 0 {
+		e.width = len(heights[0])
+	}
+	if method == GridErosion {
+		n := e.width * e.height
+		e.water = make([]float64, n)
+		e.sediment = make([]float64, n)
+		e.moved = make([]float64, n)
+		e.flux = make([][4]float64, n)
+		e.vx = make([]float64, n)
+		e.vy = make([]float64, n)
+	}
+	return e
+}
+
+func (e *Erosion) Done() bool {
+	return e.done >= e.Params.Iterations
+}
+
+func (e *Erosion) Progress() string {
+	return fmt.Sprintf("%s erosion %d/%d", e.Method, e.done, e.Params.Iterations)
+}
+
+// Step runs the next StepSize iterations.
+func (e *Erosion) Step() {
+	e.run(max(1, e.Params.StepSize))
+}
+
+// Run runs every remaining iteration at once.
+func (e *Erosion) Run() {
+	e.run(e.Params.Iterations - e.done)
+}
+
+func (e *Erosion) run(n int) {
+	if e.width < 2 || e.height < 2 {
+		e.done = e.Params.Iterations
+		return
+	}
+	n = min(n, e.Params.Iterations-e.done)
+	for i := 0; i < n; i++ {
+		switch e.Method {
+		case DropletErosion:
+			e.droplet()
+		case GridErosion:
+			e.gridPass()
+		case ThermalErosion:
+			e.thermalPass()
+		}
+	}
+	e.done += n
+}
+
+// gradient returns the bilinearly interpolated height and slope at a point
+// inside the field.
+func (e *Erosion) gradient(x, y float64) (h, gx, gy float64) {
+	cx, cy := int(x), int(y)
+	u, v := x-float64(cx), y-float64(cy)
+	nw := e.heights[cy][cx]
+	ne := e.heights[cy][cx+1]
+	sw := e.heights[cy+1][cx]
+	se := e.heights[cy+1][cx+1]
+
+	gx = (ne-nw)*(1-v) + (se-sw)*v
+	gy = (sw-nw)*(1-u) + (se-ne)*u
+	h = nw*(1-u)*(1-v) + ne*u*(1-v) + sw*(1-u)*v + se*u*v
+	return h, gx, gy
+}
+
+// spread adds amount to the four cells around a point, weighted by how close
+// the point is to each.
+func (e *Erosion) spread(x, y, amount float64) {
+	cx, cy := int(x), int(y)
+	u, v := x-float64(cx), y-float64(cy)
+	e.heights[cy][cx] += amount * (1 - u) * (1 - v)
+	e.heights[cy][cx+1] += amount * u * (1 - v)
+	e.heights[cy+1][cx] += amount * (1 - u) * v
+	e.heights[cy+1][cx+1] += amount * u * v
+}
+
+// droplet follows one rain droplet from a random start until it stops, runs
+// off the map or evaporates.
+func (e *Erosion) droplet() {
+	p := e.Params
+	x := e.rng.Float64() * float64(e.width-1)
+	y := e.rng.Float64() * float64(e.height-1)
+	var dx, dy, sediment float64
+	speed, water := 1.0, 1.0
+
+	for life := 0; life < p.MaxLifetime; life++ {
+		h, gx, gy := e.gradient(x, y)
+
+		// Blend the previous direction with the downhill direction
+		dx = dx*p.Inertia - gx*(1-p.Inertia)
+		dy = dy*p.Inertia - gy*(1-p.Inertia)
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			return
+		}
+		dx, dy = dx/length, dy/length
+
+		nx, ny := x+dx, y+dy
+		if nx < 0 || ny < 0 || nx >= float64(e.width-1) || ny >= float64(e.height-1) {
+			return
+		}
+		newHeight, _, _ := e.gradient(nx, ny)
+		deltaH := newHeight - h
+
+		capacity := math.Max(-deltaH*speed*water*p.SedimentCapacity, p.MinCapacity)
+		if sediment > capacity || deltaH > 0 {
+			// Going uphill fills the pit behind; otherwise drop the excess
+			amount := (sediment - capacity) * p.Deposition
+			if deltaH > 0 {
+				amount = math.Min(deltaH, sediment)
+			}
+			sediment -= amount
+			e.spread(x, y, amount)
+		} else {
+			// Never dig deeper than the next point, or droplets carve pits
+			amount := math.Min((capacity-sediment)*p.ErosionRate, -deltaH)
+			sediment += amount
+			e.spread(x, y, -amount)
+		}
+
+		speed = math.Sqrt(math.Max(0, speed*speed-deltaH*p.Gravity))
+		water *= 1 - p.Evaporation
+		x, y = nx, ny
+	}
+}
+
+// gridPass advances the virtual pipe model by one time step: rain, flow
+// between neighbors, erosion and deposition, sediment transport and
+// evaporation.
+func (e *Erosion) gridPass() {
+	p := e.Params
+	w, h := e.width, e.height
+	dt := p.TimeStep
+	offsets := [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	surface := func(x, y int) float64 {
+		return e.heights[y][x]*p.PipeScale + e.water[y*w+x]
+	}
+
+	for i := range e.water {
+		e.water[i] += p.Rain * dt
+	}
+
+	// Outflow grows with the difference in water surface; the map edges are
+	// walls
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			total := 0.0
+			for d, o := range offsets {
+				nx, ny := x+o[0], y+o[1]
+				if nx < 0 || ny < 0 || nx >= w || ny >= h {
+					e.flux[i][d] = 0
+					continue
+				}
+				e.flux[i][d] = math.Max(0, e.flux[i][d]+dt*9.81*(surface(x, y)-surface(nx, ny)))
+				total += e.flux[i][d]
+			}
+			// A cell cannot send away more water than it has
+			if total*dt > e.water[i] && total > 0 {
+				scale := e.water[i] / (total * dt)
+				for d := range e.flux[i] {
+					e.flux[i][d] *= scale
+				}
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			var in [4]float64 // Inflow from the left, right, up and down neighbor
+			if x > 0 {
+				in[0] = e.flux[i-1][1]
+			}
+			if x < w-1 {
+				in[1] = e.flux[i+1][0]
+			}
+			if y > 0 {
+				in[2] = e.flux[i-w][3]
+			}
+			if y < h-1 {
+				in[3] = e.flux[i+w][2]
+			}
+			out := e.flux[i]
+			before := e.water[i]
+			e.water[i] = math.Max(0, before+dt*(in[0]+in[1]+in[2]+in[3]-out[0]-out[1]-out[2]-out[3]))
+
+			depth := (before + e.water[i]) / 2
+			if depth < 1e-6 {
+				e.vx[i], e.vy[i] = 0, 0
+				continue
+			}
+			e.vx[i] = (in[0] - out[0] + out[1] - in[1]) / 2 / depth
+			e.vy[i] = (in[2] - out[2] + out[3] - in[3]) / 2 / depth
+			if speed := math.Hypot(e.vx[i], e.vy[i]); speed > p.MaxSpeed {
+				e.vx[i] *= p.MaxSpeed / speed
+				e.vy[i] *= p.MaxSpeed / speed
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			gx := (e.heights[y][min(x+1, w-1)] - e.heights[y][max(x-1, 0)]) / 2 * p.PipeScale
+			gy := (e.heights[min(y+1, h-1)][x] - e.heights[max(y-1, 0)][x]) / 2 * p.PipeScale
+			slope := math.Hypot(gx, gy)
+			tilt := math.Max(slope/math.Sqrt(1+slope*slope), p.MinTilt)
+
+			capacity := p.SedimentCapacity * tilt * math.Hypot(e.vx[i], e.vy[i]) * math.Min(1, e.water[i]/p.MinDepth)
+			if capacity > e.sediment[i] {
+				amount := p.ErosionRate * (capacity - e.sediment[i]) * dt
+				e.heights[y][x] -= amount / p.PipeScale
+				e.sediment[i] += amount
+			} else {
+				amount := p.Deposition * (e.sediment[i] - capacity) * dt
+				e.heights[y][x] += amount / p.PipeScale
+				e.sediment[i] -= amount
+			}
+		}
+	}
+
+	// Carry suspended sediment along the flow by sampling upstream
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			sx := math.Max(0, math.Min(float64(x)-e.vx[i]*dt, float64(w-1)))
+			sy := math.Max(0, math.Min(float64(y)-e.vy[i]*dt, float64(h-1)))
+			cx, cy := min(int(sx), w-2), min(int(sy), h-2)
+			u, v := sx-float64(cx), sy-float64(cy)
+			e.moved[i] = e.sediment[cy*w+cx]*(1-u)*(1-v) + e.sediment[cy*w+cx+1]*u*(1-v) +
+				e.sediment[(cy+1)*w+cx]*(1-u)*v + e.sediment[(cy+1)*w+cx+1]*u*v
+		}
+	}
+	e.sediment, e.moved = e.moved, e.sediment
+
+	for i := range e.water {
+		e.water[i] *= 1 - p.Evaporation
+	}
+}
+
+// thermalPass moves material from every cell to each lower neighbor whose
+// drop exceeds the talus, in proportion to the excess.
+func (e *Erosion) thermalPass() {
+	p := e.Params
+	w, h := e.width, e.height
+	offsets := [8][2]int{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}}
+
+	delta := make([][]float64, h)
+	for y := range delta {
+		delta[y] = make([]float64, w)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var excess [8]float64
+			total, steepest := 0.0, 0.0
+			for d, o := range offsets {
+				nx, ny := x+o[0], y+o[1]
+				if nx < 0 || ny < 0 || nx >= w || ny >= h {
+					continue
+				}
+				// Diagonal neighbors are further away, so allow a larger drop
+				talus := p.Talus * math.Hypot(float64(o[0]), float64(o[1]))
+				if drop := e.heights[y][x] - e.heights[ny][nx]; drop > talus {
+					excess[d] = drop - talus
+					total += excess[d]
+					steepest = math.Max(steepest, excess[d])
+				}
+			}
+			if total == 0 {
+				continue
+			}
+			moved := p.ThermalRate * steepest / 2
+			delta[y][x] -= moved
+			for d, o := range offsets {
+				if excess[d] > 0 {
+					delta[y+o[1]][x+o[0]] += moved * excess[d] / total
+				}
+			}
+		}
+	}
+	for y := range delta {
+		for x := range delta[y] {
+			e.heights[y][x] += delta[y][x]
+		}
+	}
+}
+
+// Erode starts eroding the current height field, which stops the terrain
+// animating until the run is cleared. The run advances one step per update
+// and replaces any river network, which would no longer match.
+func (t *Terrain) Erode(method ErosionMethod, params ErosionParams) *Erosion {
+	t.hydrology = nil
+	t.erosion = NewErosion(t.heights, method, params, t.seed)
+	return t.erosion
+}
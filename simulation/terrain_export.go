@@ -0,0 +1,127 @@
+package simulation
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Heights returns a copy of the height field, indexed [y][x], with every
+// value normalized to 0-1.
+func (t *Terrain) Heights() [][]float64 {
+	heights := make([][]float64, len(t.heights))
+	for y := range t.heights {
+		heights[y] = make([]float64, len(t.heights[y]))
+		for x := range t.heights[y] {
+			heights[y][x] = clamp01(t.heights[y][x])
+		}
+	}
+	return heights
+}
+
+// height16 scales a height to the full 16-bit range.
+func (t *Terrain) height16(x, y int) uint16 {
+	return uint16(math.Round(clamp01(t.heights[y][x]) * math.MaxUint16))
+}
+
+// WriteHeightmapPNG writes the height field as a 16-bit grayscale PNG.
+func (t *Terrain) WriteHeightmapPNG(w io.Writer) error {
+	img := image.NewGray16(image.Rect(0, 0, t.grid.width, t.grid.height))
+	for y := 0; y < t.grid.height; y++ {
+		for x := 0; x < t.grid.width; x++ {
+			img.SetGray16(x, y, color.Gray16{Y: t.height16(x, y)})
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// WriteHeightmapPGM writes the height field as a binary 16-bit PGM, which
+// stores samples big-endian.
+func (t *Terrain) WriteHeightmapPGM(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P5\n%d %d\n%d\n", t.grid.width, t.grid.height, math.MaxUint16)
+	buf := make([]byte, 2*t.grid.width)
+	for y := 0; y < t.grid.height; y++ {
+		for x := 0; x < t.grid.width; x++ {
+			binary.BigEndian.PutUint16(buf[2*x:], t.height16(x, y))
+		}
+		bw.Write(buf)
+	}
+	return bw.Flush()
+}
+
+// WriteHeightmapRaw writes the height field as headerless little-endian
+// float32 values, row by row. Readers need the width and height separately.
+func (t *Terrain) WriteHeightmapRaw(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 4*t.grid.width)
+	for y := 0; y < t.grid.height; y++ {
+		for x := 0; x < t.grid.width; x++ {
+			binary.LittleEndian.PutUint32(buf[4*x:], math.Float32bits(float32(clamp01(t.heights[y][x]))))
+		}
+		bw.Write(buf)
+	}
+	return bw.Flush()
+}
+
+// WriteOBJ writes the terrain as a Wavefront OBJ triangle mesh with one
+// vertex per cell, y up and one unit between cells. Heights are multiplied
+// by heightScale, and each vertex carries its band's unlit color as the
+// widely supported "v x y z r g b" extension.
+func (t *Terrain) WriteOBJ(w io.Writer, heightScale float64) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Terrain %dx%d, biome %s\n", t.grid.width, t.grid.height, t.biomes[t.currentBiome].name)
+	for y := 0; y < t.grid.height; y++ {
+		for x := 0; x < t.grid.width; x++ {
+			c := color.RGBAModel.Convert(t.bandColor(x, y)).(color.RGBA)
+			fmt.Fprintf(bw, "v %d %g %d %g %g %g\n", x, clamp01(t.heights[y][x])*heightScale, y,
+				float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+		}
+	}
+
+	// OBJ indices start at 1; faces wind counter-clockwise seen from above
+	index := func(x, y int) int {
+		return y*t.grid.width + x + 1
+	}
+	for y := 0; y+1 < t.grid.height; y++ {
+		for x := 0; x+1 < t.grid.width; x++ {
+			a, b, c, d := index(x, y), index(x+1, y), index(x, y+1), index(x+1, y+1)
+			fmt.Fprintf(bw, "f %d %d %d\nf %d %d %d\n", a, c, b, b, c, d)
+		}
+	}
+	return bw.Flush()
+}
+
+// ExportHeightmap writes the height field into dir as name.png, name.pgm,
+// name.f32 and name.obj.
+func (t *Terrain) ExportHeightmap(dir, name string) error {
+	writers := map[string]func(io.Writer) error{
+		".png": t.WriteHeightmapPNG,
+		".pgm": t.WriteHeightmapPGM,
+		".f32": t.WriteHeightmapRaw,
+		".obj": func(w io.Writer) error {
+			return t.WriteOBJ(w, float64(t.grid.width)/4)
+		},
+	}
+	for ext, write := range writers {
+		f, err := os.Create(filepath.Join(dir, name+ext))
+		if err != nil {
+			return err
+		}
+		if err := write(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
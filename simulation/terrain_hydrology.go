@@ -0,0 +1,344 @@
+package simulation
+
+import (
+	"container/heap"
+	"encoding/json"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+type HydrologyParams struct {
+	RiverThreshold float64 // Upstream cells needed before a cell becomes a river
+	CarveDepth     float64 // How far the smallest river is cut into the terrain; larger ones cut deeper
+	LakeDepth      float64 // Basins shallower than this are left dry
+	Epsilon        float64 // Slope added across filled flats so every cell drains
+}
+
+var DefaultHydrologyParams = HydrologyParams{
+	RiverThreshold: 50,
+	CarveDepth:     0.01,
+	LakeDepth:      0.002,
+	Epsilon:        1e-5,
+}
+
+// d8Offsets are the eight flow directions, starting north and going
+// clockwise.
+var d8Offsets = [8][2]int{{0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}}
+
+// Hydrology is the drainage network of a height field. Every grid is indexed
+// [y][x].
+type Hydrology struct {
+	Params       HydrologyParams
+	Filled       [][]float64 // Height with every depression filled to its spill point
+	Direction    [][]int     // Index into d8Offsets of the downstream neighbor, or -1 for outlets
+	Accumulation [][]float64 // Number of cells draining through each cell, itself included
+	Lake         [][]bool
+	River        [][]bool
+	width        int
+	height       int
+}
+
+type floodCell struct {
+	x, y  int
+	level float64
+}
+
+// floodQueue is a min-heap of cells ordered by level.
+type floodQueue []floodCell
+
+func (q floodQueue) Len() int           { return len(q) }
+func (q floodQueue) Less(i, j int) bool { return q[i].level < q[j].level }
+func (q floodQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *floodQueue) Push(v any)        { *q = append(*q, v.(floodCell)) }
+func (q *floodQueue) Pop() any {
+	old := *q
+	v := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return v
+}
+
+func newBoolGrid(width, height int) [][]bool {
+	grid := make([][]bool, height)
+	for y := range grid {
+		grid[y] = make([]bool, width)
+	}
+	return grid
+}
+
+func newFloatGrid(width, height int) [][]float64 {
+	grid := make([][]float64, height)
+	for y := range grid {
+		grid[y] = make([]float64, width)
+	}
+	return grid
+}
+
+// ComputeHydrology fills depressions, routes flow and marks lakes and rivers.
+// Cells on the map edge or at or below sea level are outlets where water
+// leaves the map.
+func ComputeHydrology(heights [][]float64, seaLevel float64, params HydrologyParams) *Hydrology {
+	h := len(heights)
+	if h == 0 {
+		return &Hydrology{Params: params}
+	}
+	w := len(heights[0])
+	hy := &Hydrology{
+		Params:       params,
+		Filled:       newFloatGrid(w, h),
+		Direction:    make([][]int, h),
+		Accumulation: newFloatGrid(w, h),
+		Lake:         newBoolGrid(w, h),
+		River:        newBoolGrid(w, h),
+		width:        w,
+		height:       h,
+	}
+	for y := range hy.Direction {
+		hy.Direction[y] = make([]int, w)
+	}
+
+	hy.fill(heights, seaLevel)
+	hy.route(heights, seaLevel)
+	hy.accumulate()
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			hy.Lake[y][x] = heights[y][x] > seaLevel && hy.Filled[y][x]-heights[y][x] > params.LakeDepth
+			hy.River[y][x] = heights[y][x] > seaLevel && !hy.Lake[y][x] && hy.Accumulation[y][x] >= params.RiverThreshold
+		}
+	}
+	return hy
+}
+
+// fill raises every depression to its spill point with priority-flood: the
+// outlets are flooded first, then the lowest cell on the flood's edge is
+// taken each time, so every cell is reached from its lowest way out.
+func (hy *Hydrology) fill(heights [][]float64, seaLevel float64) {
+	visited := newBoolGrid(hy.width, hy.height)
+	queue := &floodQueue{}
+	for y := 0; y < hy.height; y++ {
+		for x := 0; x < hy.width; x++ {
+			edge := x == 0 || y == 0 || x == hy.width-1 || y == hy.height-1
+			if edge || heights[y][x] <= seaLevel {
+				hy.Filled[y][x] = heights[y][x]
+				visited[y][x] = true
+				heap.Push(queue, floodCell{x, y, heights[y][x]})
+			}
+		}
+	}
+
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(floodCell)
+		for _, o := range d8Offsets {
+			nx, ny := c.x+o[0], c.y+o[1]
+			if nx < 0 || ny < 0 || nx >= hy.width || ny >= hy.height || visited[ny][nx] {
+				continue
+			}
+			visited[ny][nx] = true
+			// The epsilon keeps a slope across filled flats
+			hy.Filled[ny][nx] = math.Max(heights[ny][nx], c.level+hy.Params.Epsilon)
+			heap.Push(queue, floodCell{nx, ny, hy.Filled[ny][nx]})
+		}
+	}
+}
+
+// route points every cell at its steepest downhill neighbor on the filled
+// surface.
+func (hy *Hydrology) route(heights [][]float64, seaLevel float64) {
+	for y := 0; y < hy.height; y++ {
+		for x := 0; x < hy.width; x++ {
+			hy.Direction[y][x] = -1
+			if heights[y][x] <= seaLevel {
+				continue
+			}
+			steepest := 0.0
+			for d, o := range d8Offsets {
+				nx, ny := x+o[0], y+o[1]
+				if nx < 0 || ny < 0 || nx >= hy.width || ny >= hy.height {
+					continue
+				}
+				slope := (hy.Filled[y][x] - hy.Filled[ny][nx]) / math.Hypot(float64(o[0]), float64(o[1]))
+				if slope > steepest {
+					steepest = slope
+					hy.Direction[y][x] = d
+				}
+			}
+		}
+	}
+}
+
+// accumulate passes each cell's flow downstream, visiting cells from the
+// highest filled level down so every cell is complete before it is passed on.
+func (hy *Hydrology) accumulate() {
+	order := make([]cellPos, 0, hy.width*hy.height)
+	for y := 0; y < hy.height; y++ {
+		for x := 0; x < hy.width; x++ {
+			hy.Accumulation[y][x] = 1
+			order = append(order, cellPos{x, y})
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return hy.Filled[order[i].y][order[i].x] > hy.Filled[order[j].y][order[j].x]
+	})
+	for _, c := range order {
+		if nx, ny, ok := hy.downstream(c.x, c.y); ok {
+			hy.Accumulation[ny][nx] += hy.Accumulation[c.y][c.x]
+		}
+	}
+}
+
+func (hy *Hydrology) downstream(x, y int) (int, int, bool) {
+	d := hy.Direction[y][x]
+	if d < 0 {
+		return 0, 0, false
+	}
+	return x + d8Offsets[d][0], y + d8Offsets[d][1], true
+}
+
+// Carve lowers every river cell into the terrain, deeper the more water it
+// carries.
+func (hy *Hydrology) Carve(heights [][]float64) {
+	for y := range hy.River {
+		for x := range hy.River[y] {
+			if hy.River[y][x] {
+				heights[y][x] -= hy.Params.CarveDepth * (1 + math.Log2(hy.Accumulation[y][x]/hy.Params.RiverThreshold))
+			}
+		}
+	}
+}
+
+// RiverPoint is a vertex of a river polyline, at a cell center in cell
+// coordinates.
+type RiverPoint struct {
+	X, Y         float64
+	Accumulation float64
+}
+
+// Rivers traces the river cells into polylines. Each starts at a source or
+// where a river leaves a lake, and ends where it joins a river already
+// traced, a lake or the sea, including that last point so the lines connect.
+func (hy *Hydrology) Rivers() [][]RiverPoint {
+	// Sources are river cells that no other river cell drains into
+	fed := newBoolGrid(hy.width, hy.height)
+	for y := 0; y < hy.height; y++ {
+		for x := 0; x < hy.width; x++ {
+			if nx, ny, ok := hy.downstream(x, y); ok && hy.River[y][x] {
+				fed[ny][nx] = true
+			}
+		}
+	}
+
+	visited := newBoolGrid(hy.width, hy.height)
+	var rivers [][]RiverPoint
+	for y := 0; y < hy.height; y++ {
+		for x := 0; x < hy.width; x++ {
+			if !hy.River[y][x] || fed[y][x] {
+				continue
+			}
+			var line []RiverPoint
+			cx, cy := x, y
+			for {
+				line = append(line, RiverPoint{float64(cx) + 0.5, float64(cy) + 0.5, hy.Accumulation[cy][cx]})
+				if !hy.River[cy][cx] || visited[cy][cx] {
+					break
+				}
+				visited[cy][cx] = true
+				nx, ny, ok := hy.downstream(cx, cy)
+				if !ok {
+					break
+				}
+				cx, cy = nx, ny
+			}
+			if len(line) > 1 {
+				rivers = append(rivers, line)
+			}
+		}
+	}
+	return rivers
+}
+
+// WriteRiversGeoJSON writes the river polylines as a GeoJSON feature
+// collection of LineStrings in cell coordinates, with the flow at each
+// vertex as a property.
+func (hy *Hydrology) WriteRiversGeoJSON(w io.Writer) error {
+	type geometry struct {
+		Type        string       `json:"type"`
+		Coordinates [][2]float64 `json:"coordinates"`
+	}
+	type feature struct {
+		Type       string         `json:"type"`
+		Geometry   geometry       `json:"geometry"`
+		Properties map[string]any `json:"properties"`
+	}
+
+	collection := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+
+	for i, river := range hy.Rivers() {
+		coords := make([][2]float64, len(river))
+		flow := make([]float64, len(river))
+		for j, p := range river {
+			coords[j] = [2]float64{p.X, p.Y}
+			flow[j] = p.Accumulation
+		}
+		collection.Features = append(collection.Features, feature{
+			Type:       "Feature",
+			Geometry:   geometry{Type: "LineString", Coordinates: coords},
+			Properties: map[string]any{"id": i, "accumulation": flow},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(collection)
+}
+
+var (
+	lakeColor  = color.RGBA{70, 110, 200, 255}
+	riverColor = color.RGBA{40, 90, 220, 255}
+)
+
+// ComputeRivers stops any erosion run, then fills lakes and carves rivers
+// into the current height field, taking the sea to end where the current
+// biome's water does. The terrain stops animating until the network is
+// cleared.
+func (t *Terrain) ComputeRivers(params HydrologyParams) *Hydrology {
+	t.erosion = nil
+	t.hydrology = ComputeHydrology(t.heights, t.seaLevel(), params)
+	t.hydrology.Carve(t.heights)
+	t.rivers = t.hydrology.Rivers()
+	t.reclassify()
+	return t.hydrology
+}
+
+func (t *Terrain) ExportRivers(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := t.hydrology.WriteRiversGeoJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// drawRivers strokes the river polylines over the cells, wider where more
+// water flows.
+func (t *Terrain) drawRivers(screen *ebiten.Image) {
+	threshold := t.hydrology.Params.RiverThreshold
+	for _, river := range t.rivers {
+		for i := 1; i < len(river); i++ {
+			a, b := river[i-1], river[i]
+			width := float32(math.Max(1, math.Min(1+math.Log2(a.Accumulation/threshold), cellSize)))
+			vector.StrokeLine(screen, float32(a.X*cellSize), float32(a.Y*cellSize), float32(b.X*cellSize), float32(b.Y*cellSize), width, riverColor, true)
+		}
+	}
+}
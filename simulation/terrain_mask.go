@@ -0,0 +1,193 @@
+package simulation
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+)
+
+// MaskShape picks the falloff that pulls the terrain down towards the edges
+// of the map.
+type MaskShape int
+
+const (
+	NoMask MaskShape = iota
+	RadialMask
+	SquareMask
+	ImageMask
+)
+
+func (s MaskShape) String() string {
+	switch s {
+	case RadialMask:
+		return "radial"
+	case SquareMask:
+		return "square"
+	case ImageMask:
+		return "image"
+	}
+	return "none"
+}
+
+// MaskParams shape the land into islands or continents. The mask is 1 where
+// land may rise and 0 where only sea is allowed; heights are multiplied by it
+// in proportion to Strength. With a LandFraction above 0, heights are then
+// remapped so that fraction of the map lies above sea level.
+type MaskParams struct {
+	Shape        MaskShape
+	Image        image.Image // Grayscale mask for ImageMask, scaled to the map
+	Falloff      float64     // Exponent of the edge falloff; higher keeps more of the middle at full height
+	Strength     float64     // 0 ignores the mask, 1 applies it fully
+	LandFraction float64     // Target share of land cells, 0 to keep the raw heights
+}
+
+var DefaultMaskParams = MaskParams{
+	Shape:    NoMask,
+	Falloff:  2,
+	Strength: 1,
+}
+
+// A single island in the middle of the map
+var IslandMaskParams = MaskParams{
+	Shape:        RadialMask,
+	Falloff:      2,
+	Strength:     1,
+	LandFraction: 0.3,
+}
+
+// Many small islands scattered over a loosely masked sea
+var ArchipelagoMaskParams = MaskParams{
+	Shape:        RadialMask,
+	Falloff:      1,
+	Strength:     0.6,
+	LandFraction: 0.2,
+}
+
+// One large landmass reaching close to the edges
+var ContinentMaskParams = MaskParams{
+	Shape:        SquareMask,
+	Falloff:      4,
+	Strength:     0.9,
+	LandFraction: 0.55,
+}
+
+// maskPresets are cycled through with the M key.
+var maskPresets = []MaskParams{
+	DefaultMaskParams,
+	IslandMaskParams,
+	ArchipelagoMaskParams,
+	ContinentMaskParams,
+}
+
+func LoadMaskPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// SetMask changes the land mask and regenerates the terrain.
+func (t *Terrain) SetMask(params MaskParams) {
+	t.maskParams = params
+	t.mask = buildMask(params, t.grid.width, t.grid.height)
+	t.discardHeightEdits()
+	t.generateTerrain()
+}
+
+// buildMask evaluates the mask for every cell, or returns nil without a mask.
+func buildMask(params MaskParams, width, height int) [][]float64 {
+	if params.Shape == NoMask || params.Shape == ImageMask && params.Image == nil {
+		return nil
+	}
+	mask := newFloatGrid(width, height)
+	for y := range mask {
+		for x := range mask[y] {
+			mask[y][x] = maskAt(params, x, y, width, height)
+		}
+	}
+	return mask
+}
+
+func maskAt(params MaskParams, x, y, width, height int) float64 {
+	if params.Shape == ImageMask {
+		bounds := params.Image.Bounds()
+		px := bounds.Min.X + x*bounds.Dx()/width
+		py := bounds.Min.Y + y*bounds.Dy()/height
+		gray := color.Gray16Model.Convert(params.Image.At(px, py)).(color.Gray16)
+		return float64(gray.Y) / 0xffff
+	}
+
+	// Distance from the center, reaching 1 at the middle of each edge
+	dx := 2*(float64(x)+0.5)/float64(width) - 1
+	dy := 2*(float64(y)+0.5)/float64(height) - 1
+	d := math.Max(math.Abs(dx), math.Abs(dy))
+	if params.Shape == RadialMask {
+		d = math.Sqrt(dx*dx + dy*dy)
+	}
+	return clamp01(1 - math.Pow(math.Min(d, 1), params.Falloff))
+}
+
+// seaLevel returns the height below which the map is water: the climate's
+// sea level in Whittaker mode, otherwise the top of the biome's lowest run of
+// water bands.
+func (t *Terrain) seaLevel() float64 {
+	if t.whittaker {
+		return t.climate.SeaLevel
+	}
+	level := 0.0
+	for _, band := range t.biomes[t.currentBiome].terrainColors {
+		if band.class != TerrainWater {
+			break
+		}
+		level = band.threshold
+	}
+	return level
+}
+
+// applyMask blends the mask into the freshly sampled heights and, with a land
+// target, stretches the heights so the right share of cells ends up above
+// sea level while keeping their order.
+func (t *Terrain) applyMask() {
+	params := t.maskParams
+	if t.mask != nil {
+		for y := range t.heights {
+			for x := range t.heights[y] {
+				h := t.heights[y][x]
+				t.heights[y][x] = lerp(h, h*t.mask[y][x], params.Strength)
+			}
+		}
+	}
+	if params.LandFraction <= 0 || params.LandFraction >= 1 {
+		return
+	}
+
+	sorted := make([]float64, 0, t.grid.width*t.grid.height)
+	for _, row := range t.heights {
+		sorted = append(sorted, row...)
+	}
+	sort.Float64s(sorted)
+	cut := sorted[int(float64(len(sorted)-1)*(1-params.LandFraction))]
+	low, high := sorted[0], sorted[len(sorted)-1]
+	sea := t.seaLevel()
+
+	for y := range t.heights {
+		for x := range t.heights[y] {
+			h := t.heights[y][x]
+			if h <= cut {
+				if cut > low {
+					h = sea * (h - low) / (cut - low)
+				} else {
+					h = sea
+				}
+			} else {
+				h = sea + (1-sea)*(h-cut)/(high-cut)
+			}
+			t.heights[y][x] = h
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package simulation
+
+import (
+	"container/heap"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// PathParams set what it costs to cross the terrain. A step into a cell costs
+// its class's MoveCost plus SlopePenalty times the height change per cell,
+// both times the length of the step; impassable classes can't be entered.
+type PathParams struct {
+	Traits       map[TerrainClass]TerrainTraits
+	SlopePenalty float64
+}
+
+var DefaultPathParams = PathParams{
+	Traits:       DefaultTerrainTraits,
+	SlopePenalty: 50,
+}
+
+var (
+	routeColor    = color.RGBA{255, 40, 40, 255}
+	endpointColor = color.RGBA{255, 255, 255, 255}
+)
+
+const fieldAlpha = 150 // Opacity of the distance field over the terrain
+
+// PathPoint is one cell along a route, with the total cost of reaching it.
+type PathPoint struct {
+	X, Y   int
+	Height float64
+	Cost   float64
+}
+
+// pathSearch holds the result of a least-cost search from one cell.
+type pathSearch struct {
+	cost [][]float64 // Least cost from the source, +Inf where unreached
+	prev [][]int     // Index into d8Offsets of the step that reached each cell, -1 at the source
+}
+
+// search runs A* from (sx, sy) towards (gx, gy), or Dijkstra over the whole
+// map when gx is negative.
+func (t *Terrain) search(sx, sy, gx, gy int, params PathParams) pathSearch {
+	w, h := t.grid.width, t.grid.height
+	traits := make([][]TerrainTraits, h)
+	for y := range traits {
+		traits[y] = make([]TerrainTraits, w)
+		for x := range traits[y] {
+			traits[y][x] = params.Traits[t.classAt(x, y)]
+		}
+	}
+
+	// The heuristic charges the cheapest class for every remaining step and
+	// ignores slopes, so it never overestimates
+	minCost := math.Inf(1)
+	for _, tr := range params.Traits {
+		if tr.Passable {
+			minCost = math.Min(minCost, tr.MoveCost)
+		}
+	}
+	heuristic := func(x, y int) float64 {
+		if gx < 0 {
+			return 0
+		}
+		dx, dy := math.Abs(float64(x-gx)), math.Abs(float64(y-gy))
+		return minCost * (math.Max(dx, dy) + (math.Sqrt2-1)*math.Min(dx, dy))
+	}
+
+	s := pathSearch{cost: newFloatGrid(w, h), prev: make([][]int, h)}
+	for y := range s.cost {
+		s.prev[y] = make([]int, w)
+		for x := range s.cost[y] {
+			s.cost[y][x] = math.Inf(1)
+			s.prev[y][x] = -1
+		}
+	}
+	if !traits[sy][sx].Passable {
+		return s
+	}
+
+	done := newBoolGrid(w, h)
+	s.cost[sy][sx] = 0
+	queue := &floodQueue{{sx, sy, heuristic(sx, sy)}}
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(floodCell)
+		if done[c.y][c.x] {
+			continue
+		}
+		done[c.y][c.x] = true
+		if c.x == gx && c.y == gy {
+			break
+		}
+
+		for d, off := range d8Offsets {
+			nx, ny := c.x+off[0], c.y+off[1]
+			if nx < 0 || ny < 0 || nx >= w || ny >= h || done[ny][nx] || !traits[ny][nx].Passable {
+				continue
+			}
+			step := 1.0
+			if off[0] != 0 && off[1] != 0 {
+				// Don't squeeze diagonally between two impassable cells
+				if !traits[c.y][nx].Passable && !traits[ny][c.x].Passable {
+					continue
+				}
+				step = math.Sqrt2
+			}
+			slope := math.Abs(t.heights[ny][nx]-t.heights[c.y][c.x]) / step
+			cost := s.cost[c.y][c.x] + step*(traits[ny][nx].MoveCost+params.SlopePenalty*slope)
+			if cost < s.cost[ny][nx] {
+				s.cost[ny][nx] = cost
+				s.prev[ny][nx] = d
+				heap.Push(queue, floodCell{nx, ny, cost + heuristic(nx, ny)})
+			}
+		}
+	}
+	return s
+}
+
+// FindPath returns the least-cost route from (x0, y0) to (x1, y1) using A*,
+// or false if the end can't be reached.
+func (t *Terrain) FindPath(x0, y0, x1, y1 int, params PathParams) ([]PathPoint, bool) {
+	s := t.search(x0, y0, x1, y1, params)
+	if math.IsInf(s.cost[y1][x1], 1) {
+		return nil, false
+	}
+
+	var route []PathPoint
+	x, y := x1, y1
+	for {
+		route = append(route, PathPoint{X: x, Y: y, Height: t.heights[y][x], Cost: s.cost[y][x]})
+		d := s.prev[y][x]
+		if d < 0 {
+			break
+		}
+		x, y = x-d8Offsets[d][0], y-d8Offsets[d][1]
+	}
+	for i, j := 0, len(route)-1; i < j; i, j = i+1, j-1 {
+		route[i], route[j] = route[j], route[i]
+	}
+	return route, true
+}
+
+// DistanceField returns the least cost of reaching every cell from (x, y),
+// found with Dijkstra's algorithm. Unreachable cells are +Inf.
+func (t *Terrain) DistanceField(x, y int, params PathParams) [][]float64 {
+	return t.search(x, y, -1, -1, params).cost
+}
+
+// WritePathCSV writes a route as x,y,height,cost rows, cost being the total
+// cost of reaching each point.
+func WritePathCSV(w io.Writer, route []PathPoint) error {
+	if _, err := fmt.Fprintln(w, "x,y,height,cost"); err != nil {
+		return err
+	}
+	for _, p := range route {
+		if _, err := fmt.Fprintf(w, "%d,%d,%g,%g\n", p.X, p.Y, p.Height, p.Cost); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pathOverlay is the state of the interactive route planner. The route and
+// distance field are only recomputed when an endpoint or the field toggle
+// changes, or the terrain's heights or classes do; relighting leaves them be.
+type pathOverlay struct {
+	params    PathParams
+	points    []cellPos // The start and, once picked, the end
+	route     []PathPoint
+	field     [][]float64 // Distance field from the start, nil unless shown
+	maxCost   float64     // Highest finite cost in the field
+	showField bool
+	renderer  *fieldRenderer // Holds the field's image once drawn
+	revision  int            // Terrain revision the route and field were computed for
+}
+
+// ExportPath writes the planned route to path as CSV.
+func (t *Terrain) ExportPath(path string) error {
+	if t.paths == nil || t.paths.route == nil {
+		return fmt.Errorf("no route planned")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WritePathCSV(f, t.paths.route); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// updatePaths picks route endpoints with left clicks, the third click
+// starting over, and refreshes the route and distance field when they or
+// the terrain change.
+func (t *Terrain) updatePaths() {
+	p := t.paths
+	changed := p.revision != t.revision
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		x, y := ebiten.CursorPosition()
+		if gx, gy := x/cellSize, y/cellSize; x >= 0 && y >= 0 && gx < t.grid.width && gy < t.grid.height {
+			if len(p.points) == 2 {
+				p.points = p.points[:0]
+			}
+			p.points = append(p.points, cellPos{gx, gy})
+			changed = true
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF) {
+		p.showField = !p.showField
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	p.revision = t.revision
+	p.route, p.field = nil, nil
+	if len(p.points) == 0 {
+		return
+	}
+	start := p.points[0]
+	if p.showField {
+		p.field = t.DistanceField(start.x, start.y, p.params)
+		t.renderPathField()
+	}
+	if len(p.points) == 2 {
+		end := p.points[1]
+		p.route, _ = t.FindPath(start.x, start.y, end.x, end.y, p.params)
+	}
+}
+
+// renderPathField writes the distance field into the overlay's image, shaded
+// from the start out to the most costly reachable cell.
+func (t *Terrain) renderPathField() {
+	p := t.paths
+	if p.renderer == nil {
+		p.renderer = newFieldRenderer(t.grid.width, t.grid.height)
+	}
+	p.maxCost = 0
+	for _, row := range p.field {
+		for _, v := range row {
+			if !math.IsInf(v, 1) {
+				p.maxCost = math.Max(p.maxCost, v)
+			}
+		}
+	}
+
+	// Pixels are premultiplied, so unreachable cells stay fully clear
+	pixels := p.renderer.pixels
+	for y, row := range p.field {
+		for x, v := range row {
+			i := 4 * (y*t.grid.width + x)
+			if math.IsInf(v, 1) || p.maxCost == 0 {
+				pixels[i], pixels[i+1], pixels[i+2], pixels[i+3] = 0, 0, 0, 0
+				continue
+			}
+			c := InfernoColormap.At(v / p.maxCost)
+			pixels[i] = uint8(float64(c.R) * fieldAlpha / 255)
+			pixels[i+1] = uint8(float64(c.G) * fieldAlpha / 255)
+			pixels[i+2] = uint8(float64(c.B) * fieldAlpha / 255)
+			pixels[i+3] = uint8(fieldAlpha)
+		}
+	}
+	p.renderer.image.WritePixels(pixels)
+}
+
+// pathStatus describes the planner's state for the overlay text.
+func (t *Terrain) pathStatus() string {
+	p := t.paths
+	switch {
+	case len(p.points) == 0:
+		return "Path: click a start"
+	case len(p.points) == 1:
+		return "Path: click an end"
+	case p.route == nil:
+		return "Path: unreachable"
+	}
+	return fmt.Sprintf("Path: %d cells, cost %.1f", len(p.route), p.route[len(p.route)-1].Cost)
+}
+
+// drawPaths shades the distance field, then strokes the route and marks its
+// endpoints.
+func (t *Terrain) drawPaths(screen *ebiten.Image) {
+	p := t.paths
+	if p.field != nil {
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(cellSize, cellSize)
+		screen.DrawImage(p.renderer.image, op)
+	}
+
+	center := func(x, y int) (float32, float32) {
+		return float32(x*cellSize) + cellSize/2, float32(y*cellSize) + cellSize/2
+	}
+	for i := 1; i < len(p.route); i++ {
+		ax, ay := center(p.route[i-1].X, p.route[i-1].Y)
+		bx, by := center(p.route[i].X, p.route[i].Y)
+		vector.StrokeLine(screen, ax, ay, bx, by, 2, routeColor, true)
+	}
+	for _, pt := range p.points {
+		x, y := center(pt.x, pt.y)
+		vector.DrawFilledCircle(screen, x, y, cellSize/2+1, endpointColor, true)
+	}
+}
@@ -0,0 +1,134 @@
+package simulation
+
+import (
+	"image/color"
+	"math"
+)
+
+// ShadingParams control how Terrain lights and outlines its colors.
+type ShadingParams struct {
+	Hillshade    bool
+	SunAzimuth   float64 // Degrees clockwise from north, the top of the map
+	SunAltitude  float64 // Degrees above the horizon
+	Exaggeration float64 // Vertical scale of the 0-1 heights relative to one cell
+	Ambient      float64 // Brightness of slopes facing away from the sun
+
+	Contours        bool
+	ContourInterval float64 // Height between contour lines
+
+	// Smooth blends between neighboring bands instead of filling each band
+	// with a flat color
+	Smooth bool
+}
+
+var DefaultShadingParams = ShadingParams{
+	SunAzimuth:      315,
+	SunAltitude:     45,
+	Exaggeration:    40,
+	Ambient:         0.35,
+	ContourInterval: 0.05,
+}
+
+var contourColor = color.RGBA{40, 30, 20, 255}
+
+// SetShading changes the lighting and recolors the terrain.
+func (t *Terrain) SetShading(params ShadingParams) {
+	t.shading = params
+	t.colorize()
+}
+
+// smoothColor interpolates between the colors of neighboring bands, each
+// color sitting at the middle of its band.
+func (b Biome) smoothColor(height float64) color.RGBA {
+	bands := b.terrainColors
+	mid := func(i int) float64 {
+		low := 0.0
+		if i > 0 {
+			low = bands[i-1].threshold
+		}
+		return (low + bands[i].threshold) / 2
+	}
+	rgba := func(i int) color.RGBA {
+		return color.RGBAModel.Convert(bands[i].color).(color.RGBA)
+	}
+
+	if len(bands) == 0 {
+		return color.RGBA{}
+	}
+	if height <= mid(0) {
+		return rgba(0)
+	}
+	for i := 1; i < len(bands); i++ {
+		if height <= mid(i) {
+			f := (height - mid(i-1)) / (mid(i) - mid(i-1))
+			return lerpColor(rgba(i-1), rgba(i), f)
+		}
+	}
+	return rgba(len(bands) - 1)
+}
+
+func lerpColor(a, b color.RGBA, f float64) color.RGBA {
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(lerp(float64(x), float64(y), f)))
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+}
+
+func scaleColor(c color.RGBA, f float64) color.RGBA {
+	scale := func(v uint8) uint8 {
+		return uint8(math.Min(255, math.Round(float64(v)*f)))
+	}
+	return color.RGBA{scale(c.R), scale(c.G), scale(c.B), c.A}
+}
+
+// hillshade returns the brightness of a cell lit by the sun, from Ambient on
+// slopes facing away to 1 on slopes facing it square on.
+func (t *Terrain) hillshade(x, y int) float64 {
+	s := t.shading
+	w, h := t.grid.width, t.grid.height
+	dzdx := (t.heights[y][min(x+1, w-1)] - t.heights[y][max(x-1, 0)]) / 2 * s.Exaggeration
+	dzdy := (t.heights[min(y+1, h-1)][x] - t.heights[max(y-1, 0)][x]) / 2 * s.Exaggeration
+
+	// Surface normal, with y growing down the screen
+	nx, ny, nz := -dzdx, -dzdy, 1.0
+	length := math.Sqrt(nx*nx + ny*ny + nz*nz)
+
+	azimuth := s.SunAzimuth * math.Pi / 180
+	altitude := s.SunAltitude * math.Pi / 180
+	lx := math.Cos(altitude) * math.Sin(azimuth)
+	ly := -math.Cos(altitude) * math.Cos(azimuth)
+	lz := math.Sin(altitude)
+
+	light := math.Max(0, (nx*lx+ny*ly+nz*lz)/length)
+	return s.Ambient + (1-s.Ambient)*light
+}
+
+// onContour reports whether a contour line passes between the cell and its
+// right or lower neighbor.
+func (t *Terrain) onContour(x, y int) bool {
+	interval := t.shading.ContourInterval
+	if interval <= 0 {
+		return false
+	}
+	level := math.Floor(t.heights[y][x] / interval)
+	if x+1 < t.grid.width && math.Floor(t.heights[y][x+1]/interval) != level {
+		return true
+	}
+	return y+1 < t.grid.height && math.Floor(t.heights[y+1][x]/interval) != level
+}
+
+// shade applies the enabled shading to a cell's base color.
+func (t *Terrain) shade(x, y int, base color.Color) color.Color {
+	s := t.shading
+	if !s.Hillshade && !s.Contours {
+		return base
+	}
+	c := color.RGBAModel.Convert(base).(color.RGBA)
+	if s.Hillshade {
+		c = scaleColor(c, t.hillshade(x, y))
+	}
+	if s.Contours && t.onContour(x, y) {
+		c = lerpColor(c, contourColor, 0.6)
+	}
+	return c
+}
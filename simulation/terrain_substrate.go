@@ -0,0 +1,95 @@
+package simulation
+
+import (
+	"image/color"
+	"math"
+)
+
+// TerrainTraits describe what a terrain class means to agents living on it.
+type TerrainTraits struct {
+	Passable bool
+	MoveCost float64 // Effort to enter a cell, 1 for open ground
+	Capacity int     // Agents a single cell can hold
+}
+
+var DefaultTerrainTraits = map[TerrainClass]TerrainTraits{
+	TerrainWater:  {Passable: false, MoveCost: math.Inf(1), Capacity: 0},
+	TerrainSand:   {Passable: true, MoveCost: 1.5, Capacity: 2},
+	TerrainGrass:  {Passable: true, MoveCost: 1, Capacity: 4},
+	TerrainForest: {Passable: true, MoveCost: 2, Capacity: 3},
+	TerrainRock:   {Passable: true, MoveCost: 3, Capacity: 1},
+	TerrainSnow:   {Passable: true, MoveCost: 4, Capacity: 1},
+}
+
+// openTraits apply everywhere when an agent model runs without a terrain.
+var openTraits = TerrainTraits{Passable: true, MoveCost: 1, Capacity: math.MaxInt}
+
+// TerrainSubstrate is a generated terrain frozen as the world of an agent
+// simulation. Agent models ask it whether they may enter a cell, what it
+// costs and how many agents fit there, and draw it underneath themselves.
+type TerrainSubstrate struct {
+	terrain *Terrain
+	classes map[TerrainClass]TerrainTraits
+	traits  [][]TerrainTraits
+}
+
+func NewTerrainSubstrate(terrain *Terrain, traits map[TerrainClass]TerrainTraits) *TerrainSubstrate {
+	s := &TerrainSubstrate{terrain: terrain, classes: traits}
+	s.Refresh()
+	return s
+}
+
+// Refresh re-reads the traits of every cell after the terrain has changed.
+func (s *TerrainSubstrate) Refresh() {
+	t := s.terrain
+	s.traits = make([][]TerrainTraits, t.grid.height)
+	for y := range s.traits {
+		s.traits[y] = make([]TerrainTraits, t.grid.width)
+		for x := range s.traits[y] {
+			s.traits[y][x] = s.classes[t.classAt(x, y)]
+		}
+	}
+}
+
+func (s *TerrainSubstrate) Terrain() *Terrain {
+	return s.terrain
+}
+
+func (s *TerrainSubstrate) Width() int {
+	return s.terrain.grid.width
+}
+
+func (s *TerrainSubstrate) Height() int {
+	return s.terrain.grid.height
+}
+
+// Traits returns the traits of a cell; cells off the map are impassable.
+func (s *TerrainSubstrate) Traits(x, y int) TerrainTraits {
+	if x < 0 || y < 0 || x >= s.Width() || y >= s.Height() {
+		return TerrainTraits{MoveCost: math.Inf(1)}
+	}
+	return s.traits[y][x]
+}
+
+func (s *TerrainSubstrate) Passable(x, y int) bool {
+	return s.Traits(x, y).Passable
+}
+
+func (s *TerrainSubstrate) MoveCost(x, y int) float64 {
+	return s.Traits(x, y).MoveCost
+}
+
+func (s *TerrainSubstrate) Capacity(x, y int) int {
+	return s.Traits(x, y).Capacity
+}
+
+// Habitable reports whether at least one agent can stand on the cell.
+func (s *TerrainSubstrate) Habitable(x, y int) bool {
+	traits := s.Traits(x, y)
+	return traits.Passable && traits.Capacity > 0
+}
+
+// colorAt returns the terrain's color for a cell, to draw under the agents.
+func (s *TerrainSubstrate) colorAt(x, y int) color.Color {
+	return s.terrain.grid.cells[y][x]
+}
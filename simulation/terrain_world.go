@@ -0,0 +1,216 @@
+package simulation
+
+import (
+	"container/list"
+	"fmt"
+	"image/color"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+const (
+	chunkSize       = 32  // Cells along each side of a chunk
+	maxCachedChunks = 256 // Least recently used chunks beyond this are dropped
+	panSpeed        = 3   // Cells per update while a pan key is held
+)
+
+type chunkKey struct {
+	x, y int
+}
+
+// terrainChunk is a square of the world generated in one go, with its colors
+// already uploaded to an image.
+type terrainChunk struct {
+	key     chunkKey
+	heights []float64
+	image   *ebiten.Image
+}
+
+// TerrainWorld is an endless, static terrain generated chunk by chunk from
+// world coordinates as the view pans over it. Only recently seen chunks are
+// kept.
+type TerrainWorld struct {
+	width        int // View size in cells
+	height       int
+	seed         int64
+	freq         float64
+	alpha        float64
+	beta         float64
+	octaves      int
+	noise        NoiseSource
+	noiseType    NoiseType
+	biomes       []Biome
+	currentBiome int
+
+	originX, originY float64 // World cell at the top left of the view
+	dragging         bool
+	dragX, dragY     int
+
+	chunks map[chunkKey]*list.Element
+	lru    *list.List // Front is the most recently used chunk
+}
+
+func NewTerrainWorld(width, height int, seed int64, biomes []Biome) *TerrainWorld {
+	w := &TerrainWorld{
+		width:   width,
+		height:  height,
+		seed:    seed,
+		freq:    0.05,
+		alpha:   2,
+		beta:    2,
+		octaves: 3,
+		biomes:  biomes,
+		chunks:  make(map[chunkKey]*list.Element),
+		lru:     list.New(),
+	}
+	w.rebuild()
+	return w
+}
+
+// rebuild recreates the noise and drops every cached chunk, after a change
+// that affects the whole world.
+func (w *TerrainWorld) rebuild() {
+	w.noise = NewNoise(w.noiseType, w.alpha, w.beta, w.octaves, w.seed)
+	for e := w.lru.Front(); e != nil; e = e.Next() {
+		e.Value.(*terrainChunk).image.Deallocate()
+	}
+	w.chunks = make(map[chunkKey]*list.Element)
+	w.lru.Init()
+}
+
+func chunkOf(v int) int {
+	return int(math.Floor(float64(v) / chunkSize))
+}
+
+// chunk returns the chunk at chunk coordinates (cx, cy), generating it if it
+// is not cached and evicting the least recently used chunk when the cache is
+// full.
+func (w *TerrainWorld) chunk(cx, cy int) *terrainChunk {
+	key := chunkKey{cx, cy}
+	if e, ok := w.chunks[key]; ok {
+		w.lru.MoveToFront(e)
+		return e.Value.(*terrainChunk)
+	}
+
+	c := w.generateChunk(key)
+	w.chunks[key] = w.lru.PushFront(c)
+	for w.lru.Len() > maxCachedChunks {
+		oldest := w.lru.Back()
+		evicted := w.lru.Remove(oldest).(*terrainChunk)
+		delete(w.chunks, evicted.key)
+		evicted.image.Deallocate()
+	}
+	return c
+}
+
+func (w *TerrainWorld) generateChunk(key chunkKey) *terrainChunk {
+	c := &terrainChunk{
+		key:     key,
+		heights: make([]float64, chunkSize*chunkSize),
+		image:   ebiten.NewImage(chunkSize, chunkSize),
+	}
+	biome := w.biomes[w.currentBiome]
+	pixels := make([]byte, 4*chunkSize*chunkSize)
+	for y := 0; y < chunkSize; y++ {
+		for x := 0; x < chunkSize; x++ {
+			i := y*chunkSize + x
+			wx, wy := key.x*chunkSize+x, key.y*chunkSize+y
+			c.heights[i] = (w.noise.Noise3D(float64(wx)*w.freq, float64(wy)*w.freq, 0) + 1) / 2
+
+			var col color.RGBA
+			if band, ok := biome.band(c.heights[i]); ok {
+				col = color.RGBAModel.Convert(band.color).(color.RGBA)
+			}
+			pixels[4*i], pixels[4*i+1], pixels[4*i+2], pixels[4*i+3] = col.R, col.G, col.B, col.A
+		}
+	}
+	c.image.WritePixels(pixels)
+	return c
+}
+
+// HeightAt returns the height of any world cell, generating its chunk if
+// needed.
+func (w *TerrainWorld) HeightAt(wx, wy int) float64 {
+	cx, cy := chunkOf(wx), chunkOf(wy)
+	c := w.chunk(cx, cy)
+	return c.heights[(wy-cy*chunkSize)*chunkSize+(wx-cx*chunkSize)]
+}
+
+// Pan moves the view by (dx, dy) cells.
+func (w *TerrainWorld) Pan(dx, dy float64) {
+	w.originX += dx
+	w.originY += dy
+}
+
+// visibleChunks calls fn for every chunk overlapping the view.
+func (w *TerrainWorld) visibleChunks(fn func(cx, cy int)) {
+	x0, y0 := chunkOf(int(math.Floor(w.originX))), chunkOf(int(math.Floor(w.originY)))
+	x1 := chunkOf(int(math.Floor(w.originX)) + w.width)
+	y1 := chunkOf(int(math.Floor(w.originY)) + w.height)
+	for cy := y0; cy <= y1; cy++ {
+		for cx := x0; cx <= x1; cx++ {
+			fn(cx, cy)
+		}
+	}
+}
+
+func (w *TerrainWorld) Update() error {
+	// Pan with the arrow keys or WASD
+	for key, dir := range map[ebiten.Key][2]float64{
+		ebiten.KeyArrowLeft: {-1, 0}, ebiten.KeyA: {-1, 0},
+		ebiten.KeyArrowRight: {1, 0}, ebiten.KeyD: {1, 0},
+		ebiten.KeyArrowUp: {0, -1}, ebiten.KeyW: {0, -1},
+		ebiten.KeyArrowDown: {0, 1}, ebiten.KeyS: {0, 1},
+	} {
+		if ebiten.IsKeyPressed(key) {
+			w.Pan(dir[0]*panSpeed, dir[1]*panSpeed)
+		}
+	}
+
+	// Or drag the map with the left mouse button
+	x, y := ebiten.CursorPosition()
+	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+		if w.dragging {
+			w.Pan(float64(w.dragX-x)/cellSize, float64(w.dragY-y)/cellSize)
+		}
+		w.dragging, w.dragX, w.dragY = true, x, y
+	} else {
+		w.dragging = false
+	}
+
+	// Right-click changes the biome and N the noise type
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) {
+		w.currentBiome = (w.currentBiome + 1) % len(w.biomes)
+		w.rebuild()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyN) {
+		w.noiseType = (w.noiseType + 1) % (WarpedNoiseType + 1)
+		w.rebuild()
+	}
+
+	// Generate newly visible chunks here so Draw only has to blit
+	w.visibleChunks(func(cx, cy int) {
+		w.chunk(cx, cy)
+	})
+	return nil
+}
+
+func (w *TerrainWorld) Draw(screen *ebiten.Image) {
+	w.visibleChunks(func(cx, cy int) {
+		c := w.chunk(cx, cy)
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(cellSize, cellSize)
+		op.GeoM.Translate((float64(cx*chunkSize)-w.originX)*cellSize, (float64(cy*chunkSize)-w.originY)*cellSize)
+		screen.DrawImage(c.image, op)
+	})
+
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Biome: %s  Noise: %s", w.biomes[w.currentBiome].name, w.noiseType), 10, 10)
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("Position: %.0f, %.0f  Chunks cached: %d", w.originX, w.originY, w.lru.Len()), 10, 25)
+}
+
+func (w *TerrainWorld) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return w.width * cellSize, w.height * cellSize
+}